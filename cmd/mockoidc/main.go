@@ -0,0 +1,238 @@
+package main
+
+/**
+ * @Description: 本地 OIDC 模拟提供方，仅用于开发和联调 OIDC 登录
+ * 授权请求不需要输入密码，直接以 login_hint 参数（默认 MOCK_OIDC_USER）指定的用户身份回调
+ *
+ * 启动：go run ./cmd/mockoidc
+ * 上传服务配置：OIDC_ISSUER=http://localhost:9999 OIDC_CLIENT_ID=file-storage
+ */
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	keyID        = "mock-key"
+	codeTTL      = time.Minute
+	idTokenTTL   = 5 * time.Minute
+	defaultEmail = "@example.com"
+)
+
+type authCode struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          string
+	expiresAt     time.Time
+}
+
+var (
+	issuer       = getEnv("MOCK_OIDC_ISSUER", "http://localhost:9999")
+	clientID     = getEnv("MOCK_OIDC_CLIENT_ID", "file-storage")
+	clientSecret = getEnv("MOCK_OIDC_CLIENT_SECRET", "")
+	defaultUser  = getEnv("MOCK_OIDC_USER", "alice")
+
+	signingKey *rsa.PrivateKey
+
+	codesMu sync.Mutex
+	codes   = make(map[string]*authCode)
+)
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func main() {
+	var err error
+	signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("generate key failed: %v", err)
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", discoveryHandler)
+	http.HandleFunc("/authorize", authorizeHandler)
+	http.HandleFunc("/token", tokenHandler)
+	http.HandleFunc("/jwks", jwksHandler)
+
+	addr := getEnv("MOCK_OIDC_ADDR", ":9999")
+	log.Printf("OIDC 模拟提供方监听在 %s (issuer: %s, client_id: %s)", addr, issuer, clientID)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Fatalf("启动服务失败: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code, desc string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": desc})
+}
+
+func discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorizeHandler 直接同意授权并回调
+func authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != clientID {
+		http.Error(w, "invalid response_type or client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user := q.Get("login_hint")
+	if user == "" {
+		user = defaultUser
+	}
+
+	code := randomString()
+	codesMu.Lock()
+	codes[code] = &authCode{
+		clientID:      clientID,
+		redirectURI:   redirectURI.String(),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		user:          user,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	codesMu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	// 客户端认证：client_secret_basic 或 client_secret_post
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if id != clientID || (clientSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(clientSecret)) != 1) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	codesMu.Lock()
+	c := codes[r.FormValue("code")]
+	delete(codes, r.FormValue("code"))
+	codesMu.Unlock()
+	if c == nil || time.Now().After(c.expiresAt) || c.clientID != id {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.FormValue("redirect_uri") != c.redirectURI {
+		tokenError(w, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != c.codeChallenge {
+		tokenError(w, "invalid_grant", "code_verifier mismatch")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                issuer,
+		"aud":                clientID,
+		"sub":                "mock-" + c.user,
+		"iat":                now.Unix(),
+		"exp":                now.Add(idTokenTTL).Unix(),
+		"nonce":              c.nonce,
+		"email":              c.user + defaultEmail,
+		"email_verified":     true,
+		"preferred_username": c.user,
+		"name":               c.user,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(signingKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken := randomString()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := signingKey.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
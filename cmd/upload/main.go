@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"file-storage-linhe/internal/cache/redis"
 	"file-storage-linhe/internal/consumer"
 	"file-storage-linhe/internal/db"
 	"file-storage-linhe/internal/handler"
 	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mailer"
 	"file-storage-linhe/internal/mq"
 	"file-storage-linhe/internal/store"
 
@@ -19,14 +21,36 @@ func main() {
 		log.Fatalf("init db failed: %v", err)
 	}
 
-	if err := store.InitMinio(); err != nil {
-		log.Fatalf("init minio failed: %v", err)
+	// ADMIN_USERS 中的用户启动时提升为管理员
+	if err := db.EnsureAdminRoles(context.Background()); err != nil {
+		log.Fatalf("init admin roles failed: %v", err)
+	}
+
+	// 加载 TOTP 密钥的加密密钥，并加密升级前的明文密钥；未配置时两步验证不可用，其余功能照常
+	if err := db.InitTOTPKey(); errors.Is(err, db.ErrTOTPKeyNotConfigured) {
+		log.Println("未配置 TOTP_SECRET_KEY，两步验证的绑定和口令校验不可用")
+	} else if err != nil {
+		log.Fatalf("init totp key failed: %v", err)
+	} else if err := db.EncryptLegacyTOTPSecrets(context.Background()); err != nil {
+		log.Fatalf("encrypt totp secrets failed: %v", err)
+	}
+
+	if err := store.InitStore(); err != nil {
+		log.Fatalf("init object store failed: %v", err)
 	}
 
 	if err := redis.InitRedis(context.Background()); err != nil {
 		log.Fatalf("init redis failed: %v", err)
 	}
 
+	if err := auth.InitKeys(); err != nil {
+		log.Fatalf("init jwt keys failed: %v", err)
+	}
+
+	if err := mailer.InitMailer(); err != nil {
+		log.Fatalf("init mailer failed: %v", err)
+	}
+
 	if err := mq.InitRabbitMQ(); err != nil {
 		log.Fatalf("init rabbitmq failed: %v", err)
 	}
@@ -41,27 +65,113 @@ func main() {
 		log.Fatalf("start operation log consumer failed: %v", err)
 	}
 
+	// 启动过期分片清理任务
+	consumer.StartMultipartReaper()
+
+	// 启动 JWT 密钥轮换任务
+	auth.StartKeyRotation()
+
 	// 用户接口
+	http.HandleFunc("/.well-known/jwks.json", handler.RecoverMiddleware(auth.JWKSHandler))
 	http.HandleFunc("/user/signup", handler.RecoverMiddleware(handler.SignupHandler))
 	http.HandleFunc("/user/signin", handler.RecoverMiddleware(handler.SigninHandler))
 	http.HandleFunc("/user/info", handler.RecoverMiddleware(auth.Auth(handler.UserInfoHandler)))
+	http.HandleFunc("/user/profile", handler.RecoverMiddleware(auth.Auth(handler.UpdateProfileHandler)))
+	http.HandleFunc("/user/email/verify", handler.RecoverMiddleware(handler.VerifyEmailHandler))
+	http.HandleFunc("/user/email/verify/resend", handler.RecoverMiddleware(auth.Auth(handler.ResendVerificationHandler)))
+	http.HandleFunc("/user/apikeys", handler.RecoverMiddleware(auth.Auth(handler.APIKeysHandler)))
+	http.HandleFunc("/user/apikeys/{id}", handler.RecoverMiddleware(auth.Auth(handler.RevokeAPIKeyHandler)))
+	http.HandleFunc("/user/password", handler.RecoverMiddleware(auth.Auth(handler.ChangePasswordHandler)))
+	http.HandleFunc("/user/password/reset/request", handler.RecoverMiddleware(handler.PasswordResetRequestHandler))
+	http.HandleFunc("/user/password/reset", handler.RecoverMiddleware(handler.PasswordResetHandler))
+	http.HandleFunc("/user/oidc/login", handler.RecoverMiddleware(handler.OIDCLoginHandler))
+	http.HandleFunc("/user/oidc/callback", handler.RecoverMiddleware(handler.OIDCCallbackHandler))
+	http.HandleFunc("/user/oidc/link", handler.RecoverMiddleware(auth.Auth(handler.OIDCLinkHandler)))
+	http.HandleFunc("/user/signin/2fa", handler.RecoverMiddleware(handler.SigninTOTPHandler))
+	http.HandleFunc("/user/2fa/enroll", handler.RecoverMiddleware(auth.Auth(handler.TOTPEnrollHandler)))
+	http.HandleFunc("/user/2fa/confirm", handler.RecoverMiddleware(auth.Auth(handler.TOTPConfirmHandler)))
+	http.HandleFunc("/user/2fa/disable", handler.RecoverMiddleware(auth.Auth(handler.TOTPDisableHandler)))
+	http.HandleFunc("/user/token/refresh", handler.RecoverMiddleware(handler.RefreshTokenHandler))
 	http.HandleFunc("/user/signout", handler.RecoverMiddleware(auth.Auth(handler.SignoutHandler)))
 	http.HandleFunc("/user/online-devices", handler.RecoverMiddleware(auth.Auth(handler.OnlineDevicesHandler)))
+	http.HandleFunc("/user/usage", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.UsageHandler)))
+	http.HandleFunc("/user/sessions", handler.RecoverMiddleware(auth.Auth(handler.ListSessionsHandler)))
+	http.HandleFunc("/user/sessions/{id}", handler.RecoverMiddleware(auth.Auth(handler.RevokeSessionHandler)))
+
+	// 管理员接口
+	// 管理员和审计员可查看，只有管理员可修改
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.RecoverMiddleware(auth.Auth(auth.RequireRole(h, db.RoleAdmin)))
+	}
+	viewer := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.RecoverMiddleware(auth.Auth(auth.RequireRole(h, db.RoleAdmin, db.RoleAuditor)))
+	}
+	http.HandleFunc("/admin/users", viewer(handler.ListUsersHandler))
+	http.HandleFunc("/admin/user/files", viewer(handler.UserFilesHandler))
+	http.HandleFunc("/admin/user/logs", viewer(handler.UserLogsAdminHandler))
+	http.HandleFunc("/admin/user/sessions", viewer(handler.UserSessionsHandler))
+	http.HandleFunc("/admin/user/unlock", admin(handler.UnlockUserHandler))
+	http.HandleFunc("/admin/user/enable", admin(handler.EnableUserHandler))
+	http.HandleFunc("/admin/user/disable", admin(handler.DisableUserHandler))
+	http.HandleFunc("/admin/user/delete", admin(handler.DeleteUserHandler))
+	http.HandleFunc("/admin/user/role", admin(handler.SetUserRoleHandler))
+	http.HandleFunc("/admin/user/logout", admin(handler.ForceLogoutHandler))
+	http.HandleFunc("/admin/user/plan", admin(handler.SetUserPlanHandler))
+	http.HandleFunc("/admin/plans", admin(handler.PlansHandler))
 
 	// 文件接口
-	http.HandleFunc("/file/upload", handler.RecoverMiddleware(auth.Auth(handler.UploadHandler)))
-	http.HandleFunc("/file/download", handler.RecoverMiddleware(auth.Auth(handler.DownloadHandler)))
-	http.HandleFunc("/file/meta", handler.RecoverMiddleware(auth.Auth(handler.FileMetaHandler)))
-	http.HandleFunc("/file/fastupload", handler.RecoverMiddleware(auth.Auth(handler.FastUploadHandler)))
-	http.HandleFunc("/file/delete", handler.RecoverMiddleware(auth.Auth(handler.DeleteHandler)))
-	http.HandleFunc("/file/multipart/init", handler.RecoverMiddleware(auth.Auth(handler.MultipartInitHandler)))
-	http.HandleFunc("/file/multipart/upload", handler.RecoverMiddleware(auth.Auth(handler.MultipartUploadHandler)))
-	http.HandleFunc("/file/multipart/status", handler.RecoverMiddleware(auth.Auth(handler.MultipartStatusHandler)))
-	http.HandleFunc("/file/multipart/complete", handler.RecoverMiddleware(auth.Auth(handler.MultipartCompleteHandler)))
+	http.HandleFunc("/file/upload", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.UploadHandler)))
+	http.HandleFunc("/file/download", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.DownloadHandler)))
+	http.HandleFunc("/file/list", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.ListFilesHandler)))
+	http.HandleFunc("/file/meta", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.FileMetaHandler)))
+	http.HandleFunc("/file/fastupload", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.FastUploadHandler)))
+	http.HandleFunc("/file/fastupload/verify", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.FastUploadVerifyHandler)))
+	http.HandleFunc("/file/delete", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeDelete, handler.DeleteHandler)))
+	http.HandleFunc("/file/multipart/init", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MultipartInitHandler)))
+	http.HandleFunc("/file/multipart/upload", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MultipartUploadHandler)))
+	http.HandleFunc("/file/multipart/status", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.MultipartStatusHandler)))
+	http.HandleFunc("/file/multipart/complete", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MultipartCompleteHandler)))
+	http.HandleFunc("/file/multipart/abort", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MultipartAbortHandler)))
+
+	// 历史版本接口
+	http.HandleFunc("/file/versions", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.FileVersionsHandler)))
+	http.HandleFunc("/file/version/download", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.DownloadFileVersionHandler)))
+	http.HandleFunc("/file/version/restore", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.RestoreFileVersionHandler)))
+	http.HandleFunc("/file/versions/prune", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeDelete, handler.PruneFileVersionsHandler)))
+
+	// 文件重命名、移动、复制接口
+	http.HandleFunc("/file/rename", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.RenameFileHandler)))
+	http.HandleFunc("/file/move", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MoveFileHandler)))
+	http.HandleFunc("/file/copy", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.CopyFileHandler)))
+
+	// 预签名直传接口
+	http.HandleFunc("/file/presign/upload", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.PresignUploadHandler)))
+	http.HandleFunc("/file/presign/upload/complete", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.PresignUploadCompleteHandler)))
+	http.HandleFunc("/file/presign/download", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.PresignDownloadHandler)))
+	http.HandleFunc("/file/multipart/presign", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.PresignChunkHandler)))
+	http.HandleFunc("/file/multipart/presign/complete", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.PresignChunkCompleteHandler)))
+
+	// 目录接口
+	http.HandleFunc("/folder/create", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.CreateFolderHandler)))
+	http.HandleFunc("/folder/rename", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.RenameFolderHandler)))
+	http.HandleFunc("/folder/move", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.MoveFolderHandler)))
+	http.HandleFunc("/folder/delete", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeDelete, handler.DeleteFolderHandler)))
+	http.HandleFunc("/folder/list", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.ListFolderHandler)))
 
 	// 回收站接口
-	http.HandleFunc("/file/recycle", handler.RecoverMiddleware(auth.Auth(handler.RecycleHandler)))
-	http.HandleFunc("/file/restore", handler.RecoverMiddleware(auth.Auth(handler.RestoreFileHandler)))
+	http.HandleFunc("/file/recycle", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.RecycleHandler)))
+	http.HandleFunc("/file/restore", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeUpload, handler.RestoreFileHandler)))
+
+	// 分享链接接口（创建需已验证邮箱，/s/{token} 公开访问）
+	http.HandleFunc("/share", handler.RecoverMiddleware(auth.Auth(handler.RequireVerifiedEmail(handler.SharesHandler))))
+	http.HandleFunc("/share/{id}", handler.RecoverMiddleware(auth.Auth(handler.RevokeShareHandler)))
+	http.HandleFunc("/s/{token}", handler.RecoverMiddleware(handler.ShareAccessHandler))
+
+	// 用户间共享接口
+	http.HandleFunc("/share/users", handler.RecoverMiddleware(auth.Auth(handler.UserSharesHandler)))
+	http.HandleFunc("/share/users/{id}", handler.RecoverMiddleware(auth.Auth(handler.RevokeUserShareHandler)))
+	http.HandleFunc("/share/with-me", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.SharedWithMeHandler)))
+	http.HandleFunc("/share/with-me/folder", handler.RecoverMiddleware(auth.AuthScope(auth.ScopeRead, handler.SharedFolderHandler)))
 
 	// 操作日志接口
 	http.HandleFunc("/user/logs", handler.RecoverMiddleware(auth.Auth(handler.UserLogsHandler)))
@@ -0,0 +1,28 @@
+package config
+
+var (
+	MaxDevicesPerUser = getEnvInt("MAX_DEVICES_PER_USER", 5) // 同一用户最多同时在线设备数（0 表示不限制）
+
+	AccessTokenTTL  = getEnvInt("ACCESS_TOKEN_TTL_SECONDS", 900)      // access token 有效期（秒）
+	RefreshTokenTTL = getEnvInt("REFRESH_TOKEN_TTL_SECONDS", 2592000) // refresh token 有效期（秒），也是会话的最长空闲时间
+
+	JWTKeyDir            = getEnv("JWT_KEY_DIR", "config/key")             // JWT 密钥目录（*.pem）
+	JWTPrivateKey        = getEnv("JWT_PRIVATE_KEY", "")                   // JWT 签名私钥 PEM 内容，设置后固定用于签名
+	JWTKeyRotateInterval = getEnvInt("JWT_KEY_ROTATE_INTERVAL_SECONDS", 0) // JWT 密钥轮换间隔（秒），0 表示不自动轮换
+)
+
+var (
+	LoginFailWindow         = getEnvInt("LOGIN_FAIL_WINDOW_SECONDS", 900)    // 登录失败计数窗口（秒）
+	LoginBackoffFreeTries   = getEnvInt("LOGIN_BACKOFF_FREE_TRIES", 3)       // 同一用户连续失败多少次后开始退避
+	LoginIPBackoffFreeTries = getEnvInt("LOGIN_IP_BACKOFF_FREE_TRIES", 10)   // 同一 IP 连续失败多少次后开始退避
+	LoginBackoffMax         = getEnvInt("LOGIN_BACKOFF_MAX_SECONDS", 300)    // 退避等待时间上限（秒）
+	LoginLockThreshold      = getEnvInt("LOGIN_LOCK_THRESHOLD", 10)          // 同一用户连续失败多少次后锁定账户
+	LoginLockDuration       = getEnvInt("LOGIN_LOCK_DURATION_SECONDS", 1800) // 账户锁定时长（秒）
+
+	TrustedProxies = getEnv("TRUSTED_PROXIES", "") // 可信反向代理的 IP / CIDR，逗号分隔；只有来自这些地址的请求才采信 X-Forwarded-For
+
+	AdminUsers = getEnv("ADMIN_USERS", "") // 启动时提升为管理员的用户名，逗号分隔（用于初始化第一个管理员）
+
+	TOTPIssuer    = getEnv("TOTP_ISSUER", "file-storage") // 认证器 App 中显示的服务名称
+	TOTPSecretKey = getEnv("TOTP_SECRET_KEY", "")         // TOTP 密钥的加密密钥（32 字节，base64 编码），未配置时两步验证不可用
+)
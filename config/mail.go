@@ -0,0 +1,19 @@
+package config
+
+var (
+	MailBackend = getEnv("MAIL_BACKEND", "log")            // smtp / file / log
+	MailFrom    = getEnv("MAIL_FROM", "noreply@localhost") // 发件人地址
+	MailFileDir = getEnv("MAIL_FILE_DIR", "./mail")        // file 模式下邮件写入的目录
+
+	SMTPHost     = getEnv("SMTP_HOST", "localhost")
+	SMTPPort     = getEnvInt("SMTP_PORT", 587)
+	SMTPUsername = getEnv("SMTP_USERNAME", "")
+	SMTPPassword = getEnv("SMTP_PASSWORD", "")
+
+	PublicBaseURL = getEnv("PUBLIC_BASE_URL", "http://localhost:8080") // 邮件链接中使用的对外访问地址
+
+	PasswordResetURL = getEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password") // 重置密码页面，token 以查询参数附加
+	PasswordResetTTL = getEnvInt("PASSWORD_RESET_TTL_SECONDS", 1800)                        // 重置密码链接有效期（秒）
+
+	EmailVerifyTTL = getEnvInt("EMAIL_VERIFY_TTL_SECONDS", 86400) // 邮箱验证链接有效期（秒）
+)
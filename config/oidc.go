@@ -0,0 +1,10 @@
+package config
+
+var (
+	OIDCIssuer        = getEnv("OIDC_ISSUER", "") // OIDC 提供方地址，为空表示不启用 OIDC 登录
+	OIDCClientID      = getEnv("OIDC_CLIENT_ID", "")
+	OIDCClientSecret  = getEnv("OIDC_CLIENT_SECRET", "")
+	OIDCRedirectURL   = getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/user/oidc/callback")
+	OIDCScopes        = getEnv("OIDC_SCOPES", "openid email profile")
+	OIDCAutoProvision = getEnv("OIDC_AUTO_PROVISION", "true") == "true" // 首次登录时自动创建本地账户
+)
@@ -0,0 +1,12 @@
+package config
+
+var (
+	StorageBackend   = getEnv("STORAGE_BACKEND", "minio") // minio / local / memory
+	LocalStorageRoot = getEnv("LOCAL_STORAGE_ROOT", "./data")
+	PresignExpiry    = getEnvInt("PRESIGN_EXPIRY_SECONDS", 900) // 预签名 URL 有效期（秒）
+
+	MultipartReapInterval = getEnvInt("MULTIPART_REAP_INTERVAL_SECONDS", 600) // 过期分片清理间隔（秒）
+
+	DefaultStorageQuota  = getEnvInt64("DEFAULT_STORAGE_QUOTA", 10*1024*1024*1024) // 用户套餐不存在时的存储配额（字节，0为不限）
+	StorageUsageCacheTTL = getEnvInt("STORAGE_USAGE_CACHE_TTL_SECONDS", 3600)      // 存储用量计数缓存时长（秒），过期后从数据库重新统计
+)
@@ -76,8 +76,11 @@ func DeleteFileMetaCache(ctx context.Context, sha1 string) error {
 
 // User 简化的用户信息结构（用于缓存）
 type User struct {
-	UserName string    `json:"user_name"`
-	SignupAt time.Time `json:"signup_at"`
+	UserName       string    `json:"user_name"`
+	SignupAt       time.Time `json:"signup_at"`
+	Email          string    `json:"email"`
+	EmailValidated bool      `json:"email_validated"`
+	Profile        string    `json:"profile"`
 }
 
 // GetUserInfoCache 从缓存获取用户基本信息
@@ -0,0 +1,69 @@
+package redis
+
+/**
+ * @Description: 邮箱验证 token
+ *   emailverify:<hash>        token 哈希 -> "用户名\n邮箱"
+ *   emailverify:user:<name>   用户当前有效的 token 哈希，重新发送或更换邮箱时旧 token 作废
+ */
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrEmailVerifyTokenInvalid 验证 token 不存在、已使用或已过期
+var ErrEmailVerifyTokenInvalid = errors.New("invalid email verify token")
+
+// SetEmailVerifyToken 保存邮箱验证 token，同一用户之前发出的 token 立即作废
+func SetEmailVerifyToken(ctx context.Context, username, email, token string, ttl time.Duration) error {
+	userKey := "emailverify:user:" + username
+	old, err := Rdb.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	hash := resetTokenHash(token)
+	pipe := Rdb.TxPipeline()
+	if old != "" {
+		pipe.Del(ctx, "emailverify:"+old)
+	}
+	pipe.Set(ctx, "emailverify:"+hash, username+"\n"+email, ttl)
+	pipe.Set(ctx, userKey, hash, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ConsumeEmailVerifyToken 校验并作废邮箱验证 token，返回对应的用户名和邮箱
+func ConsumeEmailVerifyToken(ctx context.Context, token string) (string, string, error) {
+	hash := resetTokenHash(token)
+	val, err := Rdb.GetDel(ctx, "emailverify:"+hash).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", ErrEmailVerifyTokenInvalid
+	}
+	if err != nil {
+		return "", "", err
+	}
+	username, email, ok := strings.Cut(val, "\n")
+	if !ok {
+		return "", "", ErrEmailVerifyTokenInvalid
+	}
+	Rdb.Del(ctx, "emailverify:user:"+username)
+	return username, email, nil
+}
+
+// DeleteEmailVerifyToken 作废用户尚未使用的邮箱验证 token（如解绑邮箱）
+func DeleteEmailVerifyToken(ctx context.Context, username string) error {
+	userKey := "emailverify:user:" + username
+	old, err := Rdb.Get(ctx, userKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return Rdb.Del(ctx, "emailverify:"+old, userKey).Err()
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmailVerifyToken(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name string
+		// prepare 保存 token 后执行，返回要提交的 token
+		prepare   func(t *testing.T, ctx context.Context) string
+		wantErr   error
+		wantEmail string
+	}{
+		{
+			name: "valid token",
+			prepare: func(t *testing.T, ctx context.Context) string {
+				return "token-1"
+			},
+			wantEmail: "alice@example.com",
+		},
+		{
+			name: "token used twice",
+			prepare: func(t *testing.T, ctx context.Context) string {
+				if _, _, err := ConsumeEmailVerifyToken(ctx, "token-1"); err != nil {
+					t.Fatal(err)
+				}
+				return "token-1"
+			},
+			wantErr: ErrEmailVerifyTokenInvalid,
+		},
+		{
+			name: "replaced by a newer token",
+			prepare: func(t *testing.T, ctx context.Context) string {
+				if err := SetEmailVerifyToken(ctx, "alice", "new@example.com", "token-2", ttl); err != nil {
+					t.Fatal(err)
+				}
+				return "token-1"
+			},
+			wantErr: ErrEmailVerifyTokenInvalid,
+		},
+		{
+			name: "deleted after unbinding",
+			prepare: func(t *testing.T, ctx context.Context) string {
+				if err := DeleteEmailVerifyToken(ctx, "alice"); err != nil {
+					t.Fatal(err)
+				}
+				return "token-1"
+			},
+			wantErr: ErrEmailVerifyTokenInvalid,
+		},
+		{
+			name: "unknown token",
+			prepare: func(t *testing.T, ctx context.Context) string {
+				return "forged"
+			},
+			wantErr: ErrEmailVerifyTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestRedis(t)
+			ctx := context.Background()
+			if err := SetEmailVerifyToken(ctx, "alice", "alice@example.com", "token-1", ttl); err != nil {
+				t.Fatal(err)
+			}
+
+			username, email, err := ConsumeEmailVerifyToken(ctx, tt.prepare(t, ctx))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConsumeEmailVerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (username != "alice" || email != tt.wantEmail) {
+				t.Errorf("ConsumeEmailVerifyToken() = (%q, %q), want (alice, %q)", username, email, tt.wantEmail)
+			}
+		})
+	}
+}
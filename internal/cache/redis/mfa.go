@@ -0,0 +1,62 @@
+package redis
+
+/**
+ * @Description: 两步验证登录中间状态
+ *   preauth:<hash>            密码校验通过、等待两步验证的预认证 token（只保存哈希）
+ *   totp:used:<name>:<step>   已使用过的 TOTP 时间步，防止同一口令被重放
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrPreAuthNotFound 预认证 token 不存在或已过期
+var ErrPreAuthNotFound = errors.New("pre-auth token not found")
+
+func preAuthKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "preauth:" + hex.EncodeToString(sum[:])
+}
+
+// SetPreAuth 保存预认证 token
+func SetPreAuth(ctx context.Context, token, username string, ttl time.Duration) error {
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(ctx, preAuthKey(token), "username", username, "attempts", 0)
+	pipe.Expire(ctx, preAuthKey(token), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// UsePreAuth 记录一次两步验证尝试，返回预认证 token 对应的用户名和累计尝试次数
+func UsePreAuth(ctx context.Context, token string) (string, int64, error) {
+	username, err := Rdb.HGet(ctx, preAuthKey(token), "username").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", 0, ErrPreAuthNotFound
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	attempts, err := Rdb.HIncrBy(ctx, preAuthKey(token), "attempts", 1).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	return username, attempts, nil
+}
+
+// DeletePreAuth 删除预认证 token（验证成功或尝试次数过多）
+func DeletePreAuth(ctx context.Context, token string) error {
+	return Rdb.Del(ctx, preAuthKey(token)).Err()
+}
+
+// MarkTOTPStepUsed 标记时间步已使用，返回 false 表示该口令已被使用过
+func MarkTOTPStepUsed(ctx context.Context, username string, step int64, ttl time.Duration) (bool, error) {
+	key := "totp:used:" + username + ":" + strconv.FormatInt(step, 10)
+	return Rdb.SetNX(ctx, key, 1, ttl).Result()
+}
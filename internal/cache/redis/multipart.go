@@ -0,0 +1,66 @@
+package redis
+
+/**
+ * @Description: 分片上传任务跟踪
+ * 所有进行中的上传任务记录在有序集合中（score 为过期时间），供后台清理过期任务遗留的分片对象
+ */
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	multipartSessionsKey  = "multipart:sessions"
+	multipartReclaimedKey = "multipart:reaper:reclaimed_bytes"
+)
+
+// TrackMultipartSession 记录上传任务及其过期时间
+func TrackMultipartSession(ctx context.Context, uploadID string, expireAt time.Time) error {
+	return Rdb.ZAdd(ctx, multipartSessionsKey, redis.Z{
+		Score:  float64(expireAt.Unix()),
+		Member: uploadID,
+	}).Err()
+}
+
+// UntrackMultipartSession 上传完成或终止后移除跟踪
+func UntrackMultipartSession(ctx context.Context, uploadID string) error {
+	return Rdb.ZRem(ctx, multipartSessionsKey, uploadID).Err()
+}
+
+// ListExpiredMultipartSessions 获取在 before 之前过期的上传任务，跳过前 offset 个
+func ListExpiredMultipartSessions(ctx context.Context, before time.Time, offset, limit int64) ([]string, error) {
+	return Rdb.ZRangeByScore(ctx, multipartSessionsKey, &redis.ZRangeBy{
+		Min:    "-inf",
+		Max:    strconv.FormatInt(before.Unix(), 10),
+		Offset: offset,
+		Count:  limit,
+	}).Result()
+}
+
+// MultipartSessionExists 判断上传任务元信息是否还在
+func MultipartSessionExists(ctx context.Context, uploadID string) (bool, error) {
+	n, err := Rdb.Exists(ctx, "multipart:info:"+uploadID).Result()
+	return n > 0, err
+}
+
+// DeleteMultipartSession 删除上传任务在 Redis 中的全部数据
+func DeleteMultipartSession(ctx context.Context, uploadID string) error {
+	pipe := Rdb.TxPipeline()
+	pipe.Del(ctx,
+		"multipart:info:"+uploadID,
+		"multipart:chunks:"+uploadID,
+		"multipart:checksums:"+uploadID,
+	)
+	pipe.ZRem(ctx, multipartSessionsKey, uploadID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// AddMultipartReclaimedBytes 累计清理回收的字节数，返回累计值
+func AddMultipartReclaimedBytes(ctx context.Context, n int64) (int64, error) {
+	return Rdb.IncrBy(ctx, multipartReclaimedKey, n).Result()
+}
@@ -0,0 +1,33 @@
+package redis
+
+/**
+ * @Description: OIDC 登录中间状态
+ *   oidc:state:<state>  跳转到提供方前生成的 nonce、PKCE verifier 等，回调时一次性取出
+ */
+
+import (
+	"context"
+	"time"
+)
+
+// SetOIDCState 保存授权请求状态
+func SetOIDCState(ctx context.Context, state string, values map[string]string, ttl time.Duration) error {
+	key := "oidc:state:" + state
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(ctx, key, values)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ConsumeOIDCState 取出并删除授权请求状态，不存在时返回空 map
+func ConsumeOIDCState(ctx context.Context, state string) (map[string]string, error) {
+	key := "oidc:state:" + state
+	pipe := Rdb.TxPipeline()
+	get := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return get.Val(), nil
+}
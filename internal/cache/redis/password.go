@@ -0,0 +1,68 @@
+package redis
+
+/**
+ * @Description: 重置密码 token 与邮件发送频率限制
+ *   pwreset:<hash>         token 哈希 -> 用户名
+ *   pwreset:user:<name>    用户当前有效的 token 哈希，重新申请时旧 token 作废
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrResetTokenInvalid 重置 token 不存在、已使用或已过期
+var ErrResetTokenInvalid = errors.New("invalid password reset token")
+
+func resetTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetPasswordResetToken 保存重置 token，同一用户之前申请的 token 立即作废
+func SetPasswordResetToken(ctx context.Context, username, token string, ttl time.Duration) error {
+	userKey := "pwreset:user:" + username
+	old, err := Rdb.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	hash := resetTokenHash(token)
+	pipe := Rdb.TxPipeline()
+	if old != "" {
+		pipe.Del(ctx, "pwreset:"+old)
+	}
+	pipe.Set(ctx, "pwreset:"+hash, username, ttl)
+	pipe.Set(ctx, userKey, hash, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ConsumePasswordResetToken 校验并作废重置 token，返回对应的用户名
+func ConsumePasswordResetToken(ctx context.Context, token string) (string, error) {
+	hash := resetTokenHash(token)
+	username, err := Rdb.GetDel(ctx, "pwreset:"+hash).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	Rdb.Del(ctx, "pwreset:user:"+username)
+	return username, nil
+}
+
+// AllowPasswordResetRequest 限制同一用户申请重置密码的频率
+func AllowPasswordResetRequest(ctx context.Context, username string, interval time.Duration) (bool, error) {
+	return Rdb.SetNX(ctx, "pwreset:throttle:"+username, 1, interval).Result()
+}
+
+// AllowEmailVerifyRequest 限制同一用户重发验证邮件的频率
+func AllowEmailVerifyRequest(ctx context.Context, username string, interval time.Duration) (bool, error) {
+	return Rdb.SetNX(ctx, "email:verify:throttle:"+username, 1, interval).Result()
+}
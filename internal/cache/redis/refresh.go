@@ -0,0 +1,116 @@
+package redis
+
+/**
+ * @Description: refresh token 存储、轮换与重放检测
+ * refresh token 为随机串，服务端只保存其 sha256：
+ *   refresh:<hash>  hash  {session_id, username, used}
+ * 同一会话内轮换产生的 refresh token 属于同一个 token family，会话即 family。
+ * 已使用过的 token 保留到过期，再次出现即视为重放，整个会话（family）被吊销。
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrRefreshTokenInvalid refresh token 不存在、已过期或所属会话已失效
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused refresh token 被重复使用，所属会话已被吊销
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// markRefreshUsed 原子地把 used 加 1 并返回新值；key 已过期时返回 0，
+// 不能直接 HINCRBY，否则会重新创建一个没有 TTL 的 key
+var markRefreshUsed = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+return redis.call("HINCRBY", KEYS[1], "used", 1)
+`)
+
+func refreshKey(hash string) string {
+	return "refresh:" + hash
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken 为会话签发新的 refresh token，并把会话有效期顺延 ttl
+func IssueRefreshToken(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	hash := hashRefreshToken(token)
+
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(ctx, refreshKey(hash), map[string]interface{}{
+		"session_id": s.ID,
+		"username":   s.Username,
+		"used":       0,
+	})
+	pipe.Expire(ctx, refreshKey(hash), ttl)
+	pipe.HSet(ctx, sessionKey(s.ID), "refresh_hash", hash)
+	pipe.Expire(ctx, sessionKey(s.ID), ttl)
+	pipe.Expire(ctx, userSessionsKey(s.Username), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	s.RefreshHash = hash
+	return token, nil
+}
+
+// RotateRefreshToken 使用 refresh token 换取新的 refresh token（一次性使用）
+// token 第二次出现时吊销整个会话并返回 ErrRefreshTokenReused
+func RotateRefreshToken(ctx context.Context, token string, ttl time.Duration) (*Session, string, error) {
+	hash := hashRefreshToken(token)
+	data, err := Rdb.HGetAll(ctx, refreshKey(hash)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) == 0 {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	// 原子地标记为已使用：并发请求中只有第一个能拿到 1
+	used, err := markRefreshUsed.Run(ctx, Rdb, []string{refreshKey(hash)}).Int64()
+	if err != nil {
+		return nil, "", err
+	}
+	if used == 0 {
+		// 读取之后刚好过期
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	session, err := GetSession(ctx, data["session_id"])
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if used > 1 || session.RefreshHash != hash {
+		if err := DeleteSession(ctx, session.Username, session.ID); err != nil {
+			return nil, "", err
+		}
+		return session, "", ErrRefreshTokenReused
+	}
+
+	newToken, err := IssueRefreshToken(ctx, session, ttl)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, newToken, nil
+}
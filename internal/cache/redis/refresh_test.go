@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// setupTestRedis 用 miniredis 替换 Rdb，测试结束后恢复
+func setupTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	old := Rdb
+	Rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = Rdb.Close()
+		Rdb = old
+	})
+	return mr
+}
+
+// newTestSession 创建会话并签发第一个 refresh token
+func newTestSession(t *testing.T, ctx context.Context, id string, ttl time.Duration) (*Session, string) {
+	t.Helper()
+	now := time.Now()
+	s := &Session{ID: id, Username: "alice", DeviceID: "dev-" + id, CreatedAt: now, LastSeen: now}
+	if _, err := CreateSession(ctx, s, ttl, 0); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	token, err := IssueRefreshToken(ctx, s, ttl)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+	return s, token
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	const ttl = time.Hour
+
+	tests := []struct {
+		name string
+		// prepare 返回要提交的 token
+		prepare       func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string
+		wantErr       error
+		wantSessionOK bool
+	}{
+		{
+			name: "first use rotates",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				return token
+			},
+			wantSessionOK: true,
+		},
+		{
+			name: "latest token after several rotations",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				for i := 0; i < 3; i++ {
+					_, next, err := RotateRefreshToken(ctx, token, ttl)
+					if err != nil {
+						t.Fatalf("rotation %d: %v", i, err)
+					}
+					token = next
+				}
+				return token
+			},
+			wantSessionOK: true,
+		},
+		{
+			name: "reuse of rotated token revokes session",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				if _, _, err := RotateRefreshToken(ctx, token, ttl); err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name: "unknown token",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				return "not-a-token"
+			},
+			wantErr:       ErrRefreshTokenInvalid,
+			wantSessionOK: true,
+		},
+		{
+			name: "expired token",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				mr.FastForward(ttl + time.Second)
+				return token
+			},
+			wantErr: ErrRefreshTokenInvalid,
+		},
+		{
+			name: "session logged out",
+			prepare: func(t *testing.T, ctx context.Context, mr *miniredis.Miniredis, s *Session, token string) string {
+				if err := DeleteSession(ctx, s.Username, s.ID); err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			wantErr: ErrRefreshTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := setupTestRedis(t)
+			ctx := context.Background()
+			s, token := newTestSession(t, ctx, "sid-1", ttl)
+
+			presented := tt.prepare(t, ctx, mr, s, token)
+			got, next, err := RotateRefreshToken(ctx, presented, ttl)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RotateRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if got.ID != s.ID || next == "" || next == presented {
+					t.Errorf("RotateRefreshToken() = (%s, %q), want session %s with a new token", got.ID, next, s.ID)
+				}
+				// 新 token 是会话当前的 refresh token
+				cur, err := GetSession(ctx, s.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cur.RefreshHash != hashRefreshToken(next) {
+					t.Error("session refresh_hash does not match the new token")
+				}
+			}
+
+			_, err = GetSession(ctx, s.ID)
+			if sessionOK := err == nil; sessionOK != tt.wantSessionOK {
+				t.Errorf("session exists = %v, want %v (err = %v)", sessionOK, tt.wantSessionOK, err)
+			}
+		})
+	}
+}
+
+// 重放被检测到后，攻击者或用户手里的最新 token 也一并失效
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	setupTestRedis(t)
+	ctx := context.Background()
+	const ttl = time.Hour
+	_, token := newTestSession(t, ctx, "sid-1", ttl)
+	other, otherToken := newTestSession(t, ctx, "sid-2", ttl)
+
+	_, latest, err := RotateRefreshToken(ctx, token, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RotateRefreshToken(ctx, token, ttl); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse error = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := RotateRefreshToken(ctx, latest, ttl); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("latest token after reuse: error = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	// 同一用户的其他会话不受影响
+	if got, _, err := RotateRefreshToken(ctx, otherToken, ttl); err != nil || got.ID != other.ID {
+		t.Errorf("other session rotation = (%v, %v), want session %s", got, err, other.ID)
+	}
+}
+
+func TestMarkRefreshUsedKeepsTTL(t *testing.T) {
+	mr := setupTestRedis(t)
+	ctx := context.Background()
+	key := refreshKey("expired")
+
+	used, err := markRefreshUsed.Run(ctx, Rdb, []string{key}).Int64()
+	if err != nil || used != 0 {
+		t.Fatalf("used = %d, err = %v, want 0", used, err)
+	}
+	if mr.Exists(key) {
+		t.Fatal("expired refresh token recreated")
+	}
+
+	mr.HSet(key, "used", "0")
+	mr.SetTTL(key, time.Hour)
+	used, err = markRefreshUsed.Run(ctx, Rdb, []string{key}).Int64()
+	if err != nil || used != 1 {
+		t.Fatalf("used = %d, err = %v, want 1", used, err)
+	}
+	if ttl := mr.TTL(key); ttl != time.Hour {
+		t.Errorf("ttl = %v, want %v", ttl, time.Hour)
+	}
+}
@@ -0,0 +1,203 @@
+package redis
+
+/**
+ * @Description: 多设备登录会话
+ * 每次登录创建一个会话，同一用户可同时在多个设备在线：
+ *   session:<sid>            hash  会话详情（用户、设备、UA、IP、创建时间、最后活跃时间）
+ *   user:<name>:sessions     zset  用户的会话列表，score 为最后活跃时间
+ */
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSessionNotFound 会话不存在或已过期
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session 登录会话
+type Session struct {
+	ID        string    `json:"session_id"`
+	Username  string    `json:"username"`
+	DeviceID  string    `json:"device_id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	RefreshHash string `json:"-"` // 当前有效 refresh token 的 sha256
+}
+
+func sessionKey(sid string) string {
+	return "session:" + sid
+}
+
+func userSessionsKey(username string) string {
+	return "user:" + username + ":sessions"
+}
+
+// CreateSession 创建会话
+// 同一设备重复登录时替换该设备的旧会话；在线设备数超过 maxDevices（>0 时生效）时踢掉最久未活跃的会话
+// 返回被踢下线的会话ID
+func CreateSession(ctx context.Context, s *Session, ttl time.Duration, maxDevices int) ([]string, error) {
+	var evicted []string
+
+	existing, err := ListSessions(ctx, s.Username)
+	if err != nil {
+		return nil, err
+	}
+	if s.DeviceID != "" {
+		for _, old := range existing {
+			if old.DeviceID == s.DeviceID {
+				if err := DeleteSession(ctx, s.Username, old.ID); err != nil {
+					return nil, err
+				}
+				evicted = append(evicted, old.ID)
+			}
+		}
+	}
+
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(ctx, sessionKey(s.ID), map[string]interface{}{
+		"username":   s.Username,
+		"device_id":  s.DeviceID,
+		"user_agent": s.UserAgent,
+		"ip":         s.IP,
+		"created_at": s.CreatedAt.Unix(),
+		"last_seen":  s.LastSeen.Unix(),
+	})
+	pipe.Expire(ctx, sessionKey(s.ID), ttl)
+	pipe.ZAdd(ctx, userSessionsKey(s.Username), redis.Z{Score: float64(s.LastSeen.Unix()), Member: s.ID})
+	pipe.Expire(ctx, userSessionsKey(s.Username), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	if maxDevices <= 0 {
+		return evicted, nil
+	}
+
+	// 超出设备数上限：按最后活跃时间从旧到新踢出
+	count, err := Rdb.ZCard(ctx, userSessionsKey(s.Username)).Result()
+	if err != nil {
+		return evicted, err
+	}
+	if over := count - int64(maxDevices); over > 0 {
+		oldest, err := Rdb.ZRange(ctx, userSessionsKey(s.Username), 0, over-1).Result()
+		if err != nil {
+			return evicted, err
+		}
+		for _, sid := range oldest {
+			if err := DeleteSession(ctx, s.Username, sid); err != nil {
+				return evicted, err
+			}
+			evicted = append(evicted, sid)
+		}
+	}
+	return evicted, nil
+}
+
+// GetSession 获取会话详情
+func GetSession(ctx context.Context, sid string) (*Session, error) {
+	data, err := Rdb.HGetAll(ctx, sessionKey(sid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	createdAt, _ := strconv.ParseInt(data["created_at"], 10, 64)
+	lastSeen, _ := strconv.ParseInt(data["last_seen"], 10, 64)
+	return &Session{
+		ID:        sid,
+		Username:  data["username"],
+		DeviceID:  data["device_id"],
+		UserAgent: data["user_agent"],
+		IP:        data["ip"],
+		CreatedAt: time.Unix(createdAt, 0),
+		LastSeen:  time.Unix(lastSeen, 0),
+
+		RefreshHash: data["refresh_hash"],
+	}, nil
+}
+
+// TouchSession 更新会话最后活跃时间
+func TouchSession(ctx context.Context, s *Session) error {
+	now := time.Now()
+	pipe := Rdb.TxPipeline()
+	pipe.HSet(ctx, sessionKey(s.ID), "last_seen", now.Unix())
+	pipe.ZAdd(ctx, userSessionsKey(s.Username), redis.Z{Score: float64(now.Unix()), Member: s.ID})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListSessions 列出用户的全部在线会话（按最后活跃时间倒序），顺带清理已过期的会话ID
+func ListSessions(ctx context.Context, username string) ([]*Session, error) {
+	ids, err := Rdb.ZRevRange(ctx, userSessionsKey(username), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for _, sid := range ids {
+		s, err := GetSession(ctx, sid)
+		if errors.Is(err, ErrSessionNotFound) {
+			Rdb.ZRem(ctx, userSessionsKey(username), sid)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// DeleteSession 删除会话（登出 / 远程下线），同时吊销会话当前的 refresh token
+func DeleteSession(ctx context.Context, username, sid string) error {
+	refreshHash, err := Rdb.HGet(ctx, sessionKey(sid), "refresh_hash").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	pipe := Rdb.TxPipeline()
+	if refreshHash != "" {
+		pipe.Del(ctx, refreshKey(refreshHash))
+	}
+	pipe.Del(ctx, sessionKey(sid))
+	pipe.ZRem(ctx, userSessionsKey(username), sid)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeleteOtherSessions 删除用户除 keepID 外的全部会话，keepID 为空时删除全部，返回删除的会话数
+func DeleteOtherSessions(ctx context.Context, username, keepID string) (int, error) {
+	sessions, err := ListSessions(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, s := range sessions {
+		if s.ID == keepID {
+			continue
+		}
+		if err := DeleteSession(ctx, username, s.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// GetOnlineDeviceCount 获取用户在线设备数
+func GetOnlineDeviceCount(ctx context.Context, username string) (int, error) {
+	sessions, err := ListSessions(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), nil
+}
@@ -0,0 +1,43 @@
+package redis
+
+/**
+ * @Description: 分享链接密码错误计数
+ *   share:fail:<id>  窗口内的密码错误次数，超过上限后暂时拒绝该分享的密码校验
+ */
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func shareFailKey(id int64) string {
+	return "share:fail:" + strconv.FormatInt(id, 10)
+}
+
+// IncrSharePasswordFailure 密码错误次数加一，返回窗口内的累计次数
+func IncrSharePasswordFailure(ctx context.Context, id int64, window time.Duration) (int64, error) {
+	pipe := Rdb.TxPipeline()
+	incr := pipe.Incr(ctx, shareFailKey(id))
+	pipe.ExpireNX(ctx, shareFailKey(id), window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// GetSharePasswordFailure 返回窗口内的密码错误次数和窗口剩余时间
+func GetSharePasswordFailure(ctx context.Context, id int64) (int64, time.Duration, error) {
+	n, err := Rdb.Get(ctx, shareFailKey(id)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	ttl, err := remainingTTL(ctx, shareFailKey(id))
+	return n, ttl, err
+}
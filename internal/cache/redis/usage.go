@@ -0,0 +1,61 @@
+package redis
+
+/**
+ * @Description: 用户存储用量计数
+ *   usage:<name>  已用字节数，缓存不存在时从数据库统计后写入，有效期内通过增减保持与数据库一致
+ */
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func storageUsageKey(username string) string {
+	return "usage:" + username
+}
+
+// 仅在计数存在时增减，避免在缓存过期后写入一个不完整的值
+const incrIfExistsScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("INCRBY", KEYS[1], ARGV[1])
+end
+return nil
+`
+
+// GetStorageUsage 读取用户已用存储，计数不存在时调用 dbFetcher 统计并写回
+func GetStorageUsage(ctx context.Context, username string, ttl time.Duration, dbFetcher func(context.Context, string) (int64, error)) (int64, error) {
+	used, err := Rdb.Get(ctx, storageUsageKey(username)).Int64()
+	if err == nil {
+		return used, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	used, err = dbFetcher(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	// 并发统计时以先写入的为准，之后的增减都基于它
+	if err := Rdb.SetNX(ctx, storageUsageKey(username), used, ttl).Err(); err != nil {
+		return 0, err
+	}
+	return used, nil
+}
+
+// IncrStorageUsage 已用存储增加 delta 字节（可为负），计数不存在时不做处理
+func IncrStorageUsage(ctx context.Context, username string, delta int64) error {
+	err := Rdb.Eval(ctx, incrIfExistsScript, []string{storageUsageKey(username)}, delta).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// DeleteStorageUsage 删除计数，下次读取时从数据库重新统计
+func DeleteStorageUsage(ctx context.Context, username string) error {
+	return Rdb.Del(ctx, storageUsageKey(username)).Err()
+}
@@ -2,16 +2,15 @@ package consumer
 
 import (
     "context"
+    "database/sql"
+    "errors"
     "log"
     "time"
 
-    "file-storage-linhe/config"
     cacheRedis "file-storage-linhe/internal/cache/redis"
     "file-storage-linhe/internal/db"
     "file-storage-linhe/internal/mq"
     "file-storage-linhe/internal/store"
-
-    "github.com/minio/minio-go/v7"
 )
 
 // 启动文件删除消费者
@@ -24,15 +23,35 @@ func StartFileDeleteConsumer() error {
             msg.Username, msg.FileHash, msg.DeletedAt.Format(time.RFC3339),
         )
 
+        // 旧版本发出的消息没有记录ID，按 hash 找回收站中的那一份
+        fileID := msg.UserFileID
+        if fileID == 0 {
+            uf, err := db.GetRecycledUserFileByHash(ctx, msg.Username, msg.FileHash)
+            if errors.Is(err, sql.ErrNoRows) {
+                log.Printf("File not in recycle bin, skip delete: filehash=%s", msg.FileHash)
+                return nil
+            }
+            if err != nil {
+                log.Printf("Failed to get recycled user file: %v", err)
+                return err
+            }
+            fileID = uf.ID
+        }
+
         // 1. 再查一遍用户-文件状态，防止用户在延迟期间恢复
-        status, err := db.CheckUserFileStatus(ctx, msg.Username, msg.FileHash)
+        status, err := db.CheckUserFileStatus(ctx, msg.Username, fileID)
+        if errors.Is(err, sql.ErrNoRows) {
+            // 已经被彻底删除
+            log.Printf("File has been purged, skip delete: user_file_id=%d", fileID)
+            return nil
+        }
         if err != nil {
             log.Printf("Failed to check user file status: %v", err)
             return err
         }
-        if status == 0 {
+        if status != 1 {
             // 0 表示正常（已恢复或未删除），这时不应该物理删
-            log.Printf("File has been restored, skip delete: filehash=%s", msg.FileHash)
+            log.Printf("File has been restored, skip delete: user_file_id=%d", fileID)
             return nil
         }
 
@@ -43,11 +62,31 @@ func StartFileDeleteConsumer() error {
             return nil // 元信息都没有了，说明之前可能已经删过，跳过即可
         }
 
+        // 历史版本随文件一起删除，释放配额，不再被引用的版本内容删除存储对象
+        versions, err := db.PurgeFileVersions(ctx, msg.Username, fileID)
+        if err != nil {
+            log.Printf("Failed to delete file versions: %v", err)
+            return err
+        }
+        for _, v := range versions {
+            if err := cacheRedis.IncrStorageUsage(ctx, msg.Username, -v.FileSize); err != nil {
+                _ = cacheRedis.DeleteStorageUsage(ctx, msg.Username)
+            }
+            db.ReleaseFileObject(ctx, v.FileSha1)
+        }
+
         // 3. 永久删除这一条用户-文件关系（这里是硬删）
-        if err := db.PermanentDeleteUserFile(ctx, msg.Username, msg.FileHash); err != nil {
+        purged, err := db.PermanentDeleteUserFile(ctx, msg.Username, fileID)
+        if err != nil {
             log.Printf("Failed to permanently delete user file: %v", err)
             return err
         }
+        if purged {
+            // 回收站中的文件占用配额，彻底删除后释放
+            if err := cacheRedis.IncrStorageUsage(ctx, msg.Username, -fm.FileSize); err != nil {
+                _ = cacheRedis.DeleteStorageUsage(ctx, msg.Username)
+            }
+        }
 
         // 4. 检查这个 filehash 是否还被其他用户使用
         stillUsed, err := db.ExistsUserFileByHash(ctx, msg.FileHash)
@@ -62,14 +101,9 @@ func StartFileDeleteConsumer() error {
             return nil
         }
 
-        // 5. 没人用了 → 删除 MinIO 对象 + 清缓存 + 标记 tbl_file 删除
-        if err := store.MinioClient.RemoveObject(
-            ctx,
-            config.MinioBucket,
-            fm.Location,
-            minio.RemoveObjectOptions{},
-        ); err != nil {
-            log.Printf("Failed to delete MinIO object: %v", err)
+        // 5. 没人用了 → 删除存储对象 + 清缓存 + 标记 tbl_file 删除
+        if err := store.Backend.RemoveObject(ctx, fm.Location); err != nil {
+            log.Printf("Failed to delete storage object: %v", err)
             return err
         }
 
@@ -79,4 +113,4 @@ func StartFileDeleteConsumer() error {
         log.Printf("File deleted successfully: filehash=%s, location=%s", msg.FileHash, fm.Location)
         return nil
     })
-}
\ No newline at end of file
+}
@@ -0,0 +1,172 @@
+package consumer
+
+/**
+ * @Description: 过期分片上传清理
+ * 分片上传任务在 Redis 中 24 小时后过期，但 multipart/<uploadID>/<n> 分片对象会一直留在存储中。
+ * 这里定时扫描过期任务，删除其分片对象和残留的 Redis 数据，并统计回收的字节数。
+ */
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"file-storage-linhe/config"
+	cacheRedis "file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/store"
+)
+
+const (
+	// 单次最多处理的过期任务数
+	reapBatchSize = 100
+	// 未被跟踪的分片/临时对象超过该时长视为孤儿对象（与分片任务有效期一致）
+	orphanMaxAge = 24 * time.Hour
+)
+
+// StartMultipartReaper 启动过期分片清理任务
+func StartMultipartReaper() {
+	interval := time.Duration(config.MultipartReapInterval) * time.Second
+	if interval <= 0 {
+		log.Println("过期分片清理已关闭")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reapMultipartUploads(interval)
+		}
+	}()
+	log.Printf("过期分片清理任务已启动 (间隔: %s)", interval)
+}
+
+// reapMultipartUploads 执行一轮清理，多实例部署时通过分布式锁保证同一时刻只有一个实例执行
+func reapMultipartUploads(interval time.Duration) {
+	ctx := context.Background()
+
+	lock := cacheRedis.NewLock(ctx, "lock:multipart:reaper", interval)
+	locked, err := lock.TryLock()
+	if err != nil || !locked {
+		return
+	}
+	defer lock.Unlock()
+
+	var sessions, objects int
+	var reclaimed int64
+
+	// 1. 清理已过期的上传任务；清理失败的任务仍留在过期集合中，用 failed 跳过，留到下一轮重试
+	now := time.Now()
+	var failed int64
+	for {
+		ids, err := cacheRedis.ListExpiredMultipartSessions(ctx, now, failed, reapBatchSize)
+		if err != nil {
+			log.Printf("List expired multipart sessions failed: %v", err)
+			break
+		}
+		for _, uploadID := range ids {
+			count, size, err := store.RemovePrefix(ctx, "multipart/"+uploadID+"/")
+			if err != nil {
+				log.Printf("Remove multipart chunks failed: upload_id=%s, err=%v", uploadID, err)
+				failed++
+				continue
+			}
+			if err := cacheRedis.DeleteMultipartSession(ctx, uploadID); err != nil {
+				log.Printf("Delete multipart session failed: upload_id=%s, err=%v", uploadID, err)
+				failed++
+				continue
+			}
+			sessions++
+			objects += count
+			reclaimed += size
+		}
+		if len(ids) < reapBatchSize {
+			break
+		}
+	}
+
+	// 2. 清理没有任务记录的孤儿对象（跟踪机制上线前遗留的分片、中断的临时上传）
+	orphanObjects, orphanSize := reapOrphanObjects(ctx)
+	objects += orphanObjects
+	reclaimed += orphanSize
+
+	if objects == 0 && sessions == 0 {
+		return
+	}
+
+	total, _ := cacheRedis.AddMultipartReclaimedBytes(ctx, reclaimed)
+	log.Printf("Multipart reaper: aborted_sessions=%d, removed_objects=%d, reclaimed_bytes=%d, total_reclaimed_bytes=%d",
+		sessions, objects, reclaimed, total)
+}
+
+// reapOrphanObjects 删除超过有效期且没有对应上传任务的 multipart/ 和 tmp/ 对象
+func reapOrphanObjects(ctx context.Context) (int, int64) {
+	deadline := time.Now().Add(-orphanMaxAge)
+	var count int
+	var size int64
+
+	// multipart/<uploadID>/...：任务元信息已不存在且所有对象都已超期才删除
+	chunks, err := store.Backend.ListObjects(ctx, "multipart/")
+	if err != nil {
+		log.Printf("List multipart objects failed: %v", err)
+	} else {
+		groups := make(map[string][]store.ObjectInfo)
+		for _, obj := range chunks {
+			parts := strings.SplitN(strings.TrimPrefix(obj.Key, "multipart/"), "/", 2)
+			groups[parts[0]] = append(groups[parts[0]], obj)
+		}
+		for uploadID, objs := range groups {
+			if !allOlderThan(objs, deadline) {
+				continue
+			}
+			if exists, err := cacheRedis.MultipartSessionExists(ctx, uploadID); err != nil || exists {
+				continue
+			}
+			n, s := removeObjects(ctx, objs)
+			count += n
+			size += s
+		}
+	}
+
+	// tmp/...：流式上传和预签名直传的临时对象，正常流程结束时都会删除
+	tmps, err := store.Backend.ListObjects(ctx, "tmp/")
+	if err != nil {
+		log.Printf("List tmp objects failed: %v", err)
+	} else {
+		var stale []store.ObjectInfo
+		for _, obj := range tmps {
+			if obj.LastModified.Before(deadline) {
+				stale = append(stale, obj)
+			}
+		}
+		n, s := removeObjects(ctx, stale)
+		count += n
+		size += s
+	}
+
+	return count, size
+}
+
+func allOlderThan(objs []store.ObjectInfo, deadline time.Time) bool {
+	for _, obj := range objs {
+		if !obj.LastModified.Before(deadline) {
+			return false
+		}
+	}
+	return true
+}
+
+func removeObjects(ctx context.Context, objs []store.ObjectInfo) (int, int64) {
+	var count int
+	var size int64
+	for _, obj := range objs {
+		if err := store.Backend.RemoveObject(ctx, obj.Key); err != nil {
+			log.Printf("Remove object failed: key=%s, err=%v", obj.Key, err)
+			continue
+		}
+		count++
+		size += obj.Size
+	}
+	return count, size
+}
@@ -0,0 +1,126 @@
+package db
+
+/**
+ * @Description: 个人 API Key（只保存 sha256，明文只在创建时返回一次）
+ */
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserName   string     `json:"-"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreateAt   time.Time  `json:"create_at"`
+}
+
+const apiKeyColumns = "id, user_name, name, key_prefix, scopes, expires_at, last_used_at, create_at"
+
+func scanAPIKey(scanner interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	k := &APIKey{}
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := scanner.Scan(&k.ID, &k.UserName, &k.Name, &k.KeyPrefix, &k.Scopes,
+		&expiresAt, &lastUsedAt, &k.CreateAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return k, nil
+}
+
+// 创建 API Key
+func CreateAPIKey(ctx context.Context, k *APIKey, keyHash string) error {
+	res, err := DB.ExecContext(ctx,
+		"INSERT INTO tbl_api_key (user_name, name, key_prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		k.UserName, k.Name, k.KeyPrefix, keyHash, k.Scopes, k.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	k.ID, err = res.LastInsertId()
+	k.CreateAt = time.Now()
+	return err
+}
+
+// 根据哈希查询有效（未吊销、未过期）的 API Key，所属账户被禁用或删除时视为无效
+func GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	row := DB.QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+` FROM tbl_api_key
+		WHERE key_hash = ? AND status = 0 AND (expires_at IS NULL OR expires_at > NOW())
+		AND user_name IN (SELECT user_name FROM tbl_user WHERE status IN (?, ?)) LIMIT 1`,
+		keyHash, UserStatusEnabled, UserStatusLocked)
+	return scanAPIKey(row)
+}
+
+// 列出用户未吊销的 API Key（包含已过期的）
+func ListAPIKeys(ctx context.Context, username string) ([]*APIKey, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM tbl_api_key WHERE user_name = ? AND status = 0 ORDER BY id DESC",
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// 统计用户未吊销的 API Key 数量
+func CountAPIKeys(ctx context.Context, username string) (int, error) {
+	var n int
+	err := DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM tbl_api_key WHERE user_name = ? AND status = 0",
+		username).Scan(&n)
+	return n, err
+}
+
+// 吊销 API Key，返回是否吊销成功（不存在或不属于该用户返回 false）
+func RevokeAPIKey(ctx context.Context, username string, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_api_key SET status = 1 WHERE id = ? AND user_name = ? AND status = 0",
+		id, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 更新最后使用时间，同一分钟内重复使用不再写库
+func TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE tbl_api_key SET last_used_at = NOW()
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)`,
+		id)
+	return err
+}
+
+// 吊销用户的全部 API Key（删除账户、重置密码时调用），返回吊销的数量
+func RevokeAllAPIKeys(ctx context.Context, username string) (int, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_api_key SET status = 1 WHERE user_name = ? AND status = 0",
+		username)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -2,7 +2,15 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"file-storage-linhe/internal/meta"
+	"fmt"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // 写入文件
@@ -25,30 +33,250 @@ func GetFileMeta(ctx context.Context, sha1 string) (*meta.FileMeta, error) {
 	return fm, err
 }
 
-// 插入用户文件关系
-func InsertUserFile(ctx context.Context, username, fileSha1, fileName string, fileSize int64) error {
-	_, err := DB.ExecContext(ctx,
-		"INSERT INTO tbl_user_file (user_name, file_sha1, file_name, file_size) VALUES (?, ?, ?, ?)",
-		username, fileSha1, fileName, fileSize,
+// UserFile 用户文件关系
+type UserFile struct {
+	ID         int64     `json:"id"`
+	UserName   string    `json:"user_name"`
+	FileSha1   string    `json:"file_sha1"`
+	FileName   string    `json:"file_name"`
+	FileSize   int64     `json:"file_size"`
+	MimeType   string    `json:"mime_type"`
+	ParentID   int64     `json:"parent_id"`
+	UploadAt   time.Time `json:"upload_at"`
+	LastUpdate time.Time `json:"last_update"`
+}
+
+const userFileColumns = "id, user_name, file_sha1, file_name, file_size, mime_type, parent_id, upload_at, last_update"
+
+func scanUserFiles(rows *sql.Rows) ([]*UserFile, error) {
+	defer rows.Close()
+
+	var files []*UserFile
+	for rows.Next() {
+		f := &UserFile{}
+		if err := rows.Scan(&f.ID, &f.UserName, &f.FileSha1, &f.FileName, &f.FileSize, &f.MimeType, &f.ParentID, &f.UploadAt, &f.LastUpdate); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// queryUserFile 查询单个用户文件，没有结果时返回 sql.ErrNoRows
+func queryUserFile(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) (*UserFile, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	files, err := scanUserFiles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return files[0], nil
+}
+
+// MimeTypeByName 根据文件扩展名推断 MIME 类型
+func MimeTypeByName(name string) string {
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(name))); t != "" {
+		// 去掉 "; charset=utf-8" 之类的参数，方便按类型过滤
+		if i := strings.Index(t, ";"); i != -1 {
+			t = t[:i]
+		}
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// 插入用户文件关系，返回存储用量是否增加了 uf.FileSize（新增文件或新增版本）
+// 同一目录下已有内容相同的同名文件时不重复插入；
+// 同一目录下已有同名文件且内容不同时，原内容转为历史版本，记录更新为新内容
+func InsertUserFile(ctx context.Context, uf *UserFile) (bool, error) {
+	if uf.MimeType == "" {
+		uf.MimeType = MimeTypeByName(uf.FileName)
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, uf.UserName); err != nil {
+		return false, err
+	}
+
+	var cur UserFile
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, file_sha1, file_size, upload_at FROM tbl_user_file
+		WHERE user_name = ? AND parent_id = ? AND file_name = ? AND status = 0 LIMIT 1 FOR UPDATE`,
+		uf.UserName, uf.ParentID, uf.FileName,
+	).Scan(&cur.ID, &cur.FileSha1, &cur.FileSize, &cur.UploadAt)
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO tbl_user_file (user_name, file_sha1, file_name, file_size, mime_type, parent_id) VALUES (?, ?, ?, ?, ?, ?)",
+			uf.UserName, uf.FileSha1, uf.FileName, uf.FileSize, uf.MimeType, uf.ParentID,
+		)
+		if err != nil {
+			return false, err
+		}
+		if uf.ID, err = res.LastInsertId(); err != nil {
+			return false, err
+		}
+	case err != nil:
+		return false, err
+	case cur.FileSha1 == uf.FileSha1:
+		// 重复上传相同内容
+		uf.ID = cur.ID
+		return false, nil
+	default:
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO tbl_file_version (user_file_id, user_name, file_sha1, file_size, create_at) VALUES (?, ?, ?, ?, ?)",
+			cur.ID, uf.UserName, cur.FileSha1, cur.FileSize, cur.UploadAt,
+		); err != nil {
+			return false, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE tbl_user_file SET file_sha1 = ?, file_size = ?, mime_type = ?, upload_at = NOW() WHERE id = ?",
+			uf.FileSha1, uf.FileSize, uf.MimeType, cur.ID,
+		); err != nil {
+			return false, err
+		}
+		uf.ID = cur.ID
+	}
+	return true, tx.Commit()
+}
+
+// UserFileQuery 用户文件列表查询条件
+type UserFileQuery struct {
+	UserName  string
+	ParentID  *int64   // 为 nil 时不限目录
+	Keyword   string   // 文件名包含的子串
+	Exts      []string // 扩展名（不带点，小写）
+	MimeType  string   // 完整类型如 image/png，或以 / 结尾的前缀如 image/
+	SortBy    string   // name / size / upload_time
+	Desc      bool
+	AfterVal  string // 游标：上一页最后一条的排序字段值
+	AfterID   int64  // 游标：上一页最后一条的ID（排序值相同时用ID定序）
+	HasCursor bool
+	Limit     int
+}
+
+// 排序字段 -> 列名（白名单，防止 SQL 注入）
+var userFileSortColumns = map[string]string{
+	"name":        "file_name",
+	"size":        "file_size",
+	"upload_time": "upload_at",
+}
+
+// UserFileSortValue 返回文件在指定排序字段上的值（用于生成游标）
+func UserFileSortValue(f *UserFile, sortBy string) string {
+	switch sortBy {
+	case "name":
+		return f.FileName
+	case "size":
+		return strconv.FormatInt(f.FileSize, 10)
+	default:
+		return f.UploadAt.Format("2006-01-02 15:04:05")
+	}
+}
+
+// 分页查询用户文件（基于游标的 keyset 分页）
+func QueryUserFiles(ctx context.Context, q *UserFileQuery) ([]*UserFile, error) {
+	column, ok := userFileSortColumns[q.SortBy]
+	if !ok {
+		column = userFileSortColumns["upload_time"]
+	}
+
+	where := []string{"user_name = ?", "status = 0"}
+	args := []interface{}{q.UserName}
+
+	if q.ParentID != nil {
+		where = append(where, "parent_id = ?")
+		args = append(args, *q.ParentID)
+	}
+	if q.Keyword != "" {
+		where = append(where, "file_name LIKE ?")
+		args = append(args, "%"+escapeLike(q.Keyword)+"%")
+	}
+	if len(q.Exts) > 0 {
+		var conds []string
+		for _, ext := range q.Exts {
+			conds = append(conds, "file_name LIKE ?")
+			args = append(args, "%."+escapeLike(ext))
+		}
+		where = append(where, "("+strings.Join(conds, " OR ")+")")
+	}
+	if q.MimeType != "" {
+		if strings.HasSuffix(q.MimeType, "/") {
+			where = append(where, "mime_type LIKE ?")
+			args = append(args, escapeLike(q.MimeType)+"%")
+		} else {
+			where = append(where, "mime_type = ?")
+			args = append(args, q.MimeType)
+		}
+	}
+
+	op, order := ">", "ASC"
+	if q.Desc {
+		op, order = "<", "DESC"
+	}
+	if q.HasCursor {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op))
+		args = append(args, q.AfterVal, q.AfterVal, q.AfterID)
+	}
+
+	args = append(args, q.Limit)
+	rows, err := DB.QueryContext(ctx,
+		fmt.Sprintf("SELECT %s FROM tbl_user_file WHERE %s ORDER BY %s %s, id %s LIMIT ?",
+			userFileColumns, strings.Join(where, " AND "), column, order, order),
+		args...,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return scanUserFiles(rows)
+}
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
-// 删除文件
-func DeleteUserFile(ctx context.Context, username, filehash string) error {
+// 列出目录下的文件
+func ListUserFilesInFolder(ctx context.Context, username string, parentID int64) ([]*UserFile, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE user_name = ? AND parent_id = ? AND status = 0 ORDER BY file_name",
+		username, parentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserFiles(rows)
+}
+
+// 删除文件（移入回收站）
+// 同一内容可能有多份副本，只删除指定的一份
+func DeleteUserFile(ctx context.Context, username string, id int64) error {
 	_, err := DB.ExecContext(ctx,
-		"UPDATE tbl_user_file SET status = 1 WHERE user_name = ? AND file_sha1 = ? AND status = 0",
-		username, filehash,
+		"UPDATE tbl_user_file SET status = 1 WHERE id = ? AND user_name = ? AND status = 0",
+		id, username,
 	)
 	return err
 }
 
-// 根据文件hash值判断文件是否存在
+// 根据文件hash值判断文件是否存在（作为历史版本被引用也算存在）
 func ExistsUserFileByHash(ctx context.Context, filehash string) (bool, error) {
 	var cnt int
 	err := DB.QueryRowContext(ctx,
-		"SELECT COUNT(1) FROM tbl_user_file WHERE file_sha1 = ? AND status = 0",
-		filehash,
+		`SELECT (SELECT COUNT(1) FROM tbl_user_file WHERE file_sha1 = ? AND status = 0)
+		+ (SELECT COUNT(1) FROM tbl_file_version WHERE file_sha1 = ?)`,
+		filehash, filehash,
 	).Scan(&cnt)
 	if err != nil {
 		return false, err
@@ -57,6 +285,7 @@ func ExistsUserFileByHash(ctx context.Context, filehash string) (bool, error) {
 }
 
 type RecycleBinFile struct {
+	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	FileHash string `json:"file_hash"`
 	FileName string `json:"file_name"`
@@ -67,7 +296,7 @@ type RecycleBinFile struct {
 // 获取回收站文件列表
 func GetRecycleBinFiles(ctx context.Context, username string) ([]*RecycleBinFile, error) {
 	rows, err := DB.QueryContext(ctx,
-		`SELECT user_name, file_sha1, file_name, upload_at, last_update
+		`SELECT id, user_name, file_sha1, file_name, upload_at, last_update
 		FROM tbl_user_file
 		WHERE user_name = ? AND status = 1
 		ORDER BY last_update DESC`,
@@ -81,7 +310,7 @@ func GetRecycleBinFiles(ctx context.Context, username string) ([]*RecycleBinFile
 	var files []*RecycleBinFile
 	for rows.Next() {
 		f := &RecycleBinFile{}
-		if err := rows.Scan(&f.Username, &f.FileHash, &f.FileName, &f.UploadAt, &f.LastUpadte); err != nil {
+		if err := rows.Scan(&f.ID, &f.Username, &f.FileHash, &f.FileName, &f.UploadAt, &f.LastUpadte); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
@@ -89,22 +318,79 @@ func GetRecycleBinFiles(ctx context.Context, username string) ([]*RecycleBinFile
 	return files, nil
 }
 
-// 恢复文件
-func RestoreUserFile(ctx context.Context, username, filehash string) error {
-	_, err := DB.ExecContext(ctx,
-		"UPDATE tbl_user_file SET status = 0 WHERE user_name = ? AND file_sha1 = ? AND status = 1",
+// 查询回收站中的文件
+func GetRecycledUserFile(ctx context.Context, username string, id int64) (*UserFile, error) {
+	return queryUserFile(ctx, DB,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE id = ? AND user_name = ? AND status = 1",
+		id, username,
+	)
+}
+
+// 按文件hash查询回收站中的文件，同一内容有多份时返回最早的一份
+func GetRecycledUserFileByHash(ctx context.Context, username, filehash string) (*UserFile, error) {
+	return queryUserFile(ctx, DB,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE user_name = ? AND file_sha1 = ? AND status = 1 ORDER BY id LIMIT 1",
 		username, filehash,
 	)
-	return err
 }
 
-// 永久删除文件
-func PermanentDeleteUserFile(ctx context.Context, username, filehash string) error {
-    _, err := DB.ExecContext(ctx,
-        "DELETE FROM tbl_user_file WHERE user_name = ? AND file_sha1 = ? AND status = 1",
-        username, filehash,
+// 恢复回收站中的文件，返回恢复后的文件
+// 原所在目录已被删除时，恢复到根目录；目标目录已有同名文件时按 autoRename 自动改名或返回 ErrFileNameConflict
+func RestoreUserFile(ctx context.Context, username string, id int64, autoRename bool) (*UserFile, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return nil, err
+	}
+	uf, err := queryUserFile(ctx, tx,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE id = ? AND user_name = ? AND status = 1 FOR UPDATE",
+		id, username,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if uf.ParentID != RootFolderID {
+		var cnt int
+		if err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(1) FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0",
+			uf.ParentID, username,
+		).Scan(&cnt); err != nil {
+			return nil, err
+		}
+		if cnt == 0 {
+			uf.ParentID = RootFolderID
+		}
+	}
+	if uf.FileName, err = availableFileName(ctx, tx, username, uf.ParentID, uf.FileName, uf.ID, autoRename); err != nil {
+		return nil, err
+	}
+	uf.MimeType = MimeTypeByName(uf.FileName)
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_file SET status = 0, parent_id = ?, file_name = ?, mime_type = ? WHERE id = ?",
+		uf.ParentID, uf.FileName, uf.MimeType, uf.ID,
+	); err != nil {
+		return nil, err
+	}
+	return uf, tx.Commit()
+}
+
+// 永久删除回收站中的文件，返回是否删除了记录
+func PermanentDeleteUserFile(ctx context.Context, username string, id int64) (bool, error) {
+    res, err := DB.ExecContext(ctx,
+        "DELETE FROM tbl_user_file WHERE id = ? AND user_name = ? AND status = 1",
+        id, username,
     )
-    return err
+    if err != nil {
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
 }
 
 // 永久删除文件元信息
@@ -117,14 +403,153 @@ func PermanentDeleteFileMeta(ctx context.Context, filehash string) error {
 }
 
 // 检查用户文件状态
-func CheckUserFileStatus(ctx context.Context, username, filehash string) (int, error) {
+func CheckUserFileStatus(ctx context.Context, username string, id int64) (int, error) {
     var status int
     err := DB.QueryRowContext(ctx,
-        "SELECT status FROM tbl_user_file WHERE user_name = ? AND file_sha1 = ?",
-        username, filehash,
+        "SELECT status FROM tbl_user_file WHERE id = ? AND user_name = ?",
+        id, username,
     ).Scan(&status)
 	if err != nil {
 		return -1, err
 	}
     return status, nil
-}
\ No newline at end of file
+}
+
+// 查询用户持有的文件（仅正常状态），同一内容有多份时返回最早的一份
+func GetUserFileByHash(ctx context.Context, username, filehash string) (*UserFile, error) {
+	return queryUserFile(ctx, DB,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE user_name = ? AND file_sha1 = ? AND status = 0 ORDER BY id LIMIT 1",
+		username, filehash,
+	)
+}
+
+// 按ID查询用户的文件（仅正常状态）
+func GetUserFileByID(ctx context.Context, username string, id int64) (*UserFile, error) {
+	return queryUserFile(ctx, DB,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE id = ? AND user_name = ? AND status = 0",
+		id, username,
+	)
+}
+
+// ErrFileNameConflict 目标目录已有同名文件
+var ErrFileNameConflict = errors.New("file already exists")
+
+// 自动改名时最多尝试的序号
+const maxRenameSuffix = 1000
+
+// lockUserFiles 锁住用户记录，串行化同一用户下会改变文件名、目录名或所在目录的写操作
+// tbl_user_file 上没有 (user_name, parent_id, file_name) 唯一键（回收站中允许同名），查重和写入要在同一把锁内完成
+func lockUserFiles(ctx context.Context, tx *sql.Tx, username string) error {
+	var id int64
+	return tx.QueryRowContext(ctx, "SELECT id FROM tbl_user WHERE user_name = ? FOR UPDATE", username).Scan(&id)
+}
+
+// availableFileName 确定文件在目录中的名字：没有同名文件时原样返回；
+// 有同名文件时 autoRename 为 true 则在扩展名前追加序号，如 "name (1).ext"，否则返回 ErrFileNameConflict
+// excludeID 为文件自身，重命名和移动时不和自己冲突（新建时传 0）
+func availableFileName(ctx context.Context, tx *sql.Tx, username string, parentID int64, name string, excludeID int64, autoRename bool) (string, error) {
+	exists := func(name string) (bool, error) {
+		var cnt int
+		err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(1) FROM tbl_user_file WHERE user_name = ? AND parent_id = ? AND file_name = ? AND status = 0 AND id <> ?",
+			username, parentID, name, excludeID,
+		).Scan(&cnt)
+		return cnt > 0, err
+	}
+
+	taken, err := exists(name)
+	if err != nil || !taken {
+		return name, err
+	}
+	if !autoRename {
+		return "", ErrFileNameConflict
+	}
+
+	// 以 . 开头的隐藏文件整体视为文件名
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i:]
+	}
+	for n := 1; n <= maxRenameSuffix; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if len(candidate) > 255 {
+			break
+		}
+		taken, err := exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", ErrFileNameConflict
+}
+
+// 移动和（或）重命名文件，返回最终的文件名；MIME 类型随扩展名更新
+// 重命名时 parentID 传原目录，移动时 name 传原文件名；文件不存在时返回 sql.ErrNoRows
+func MoveUserFile(ctx context.Context, username string, id, parentID int64, name string, autoRename bool) (string, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return "", err
+	}
+	if _, err := queryUserFile(ctx, tx,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE id = ? AND user_name = ? AND status = 0 FOR UPDATE",
+		id, username,
+	); err != nil {
+		return "", err
+	}
+	if name, err = availableFileName(ctx, tx, username, parentID, name, id, autoRename); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_file SET parent_id = ?, file_name = ?, mime_type = ? WHERE id = ?",
+		parentID, name, MimeTypeByName(name), id,
+	); err != nil {
+		return "", err
+	}
+	return name, tx.Commit()
+}
+
+// 复制文件：新建一条引用相同 file_sha1 的记录，不复制存储对象和历史版本
+// 目标目录已有同名文件时按 autoRename 处理，同 MoveUserFile
+func CopyUserFile(ctx context.Context, src *UserFile, parentID int64, name string, autoRename bool) (*UserFile, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, src.UserName); err != nil {
+		return nil, err
+	}
+	if name, err = availableFileName(ctx, tx, src.UserName, parentID, name, 0, autoRename); err != nil {
+		return nil, err
+	}
+
+	uf := &UserFile{
+		UserName: src.UserName,
+		FileSha1: src.FileSha1,
+		FileName: name,
+		FileSize: src.FileSize,
+		MimeType: MimeTypeByName(name),
+		ParentID: parentID,
+	}
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO tbl_user_file (user_name, file_sha1, file_name, file_size, mime_type, parent_id) VALUES (?, ?, ?, ?, ?, ?)",
+		uf.UserName, uf.FileSha1, uf.FileName, uf.FileSize, uf.MimeType, uf.ParentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if uf.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return uf, tx.Commit()
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestInsertUserFileVersioning(t *testing.T) {
+	uploadAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	selectCurrent := regexp.QuoteMeta("SELECT id, file_sha1, file_size, upload_at FROM tbl_user_file")
+	insertFile := regexp.QuoteMeta("INSERT INTO tbl_user_file")
+	insertVersion := regexp.QuoteMeta("INSERT INTO tbl_file_version")
+	updateFile := regexp.QuoteMeta("UPDATE tbl_user_file SET file_sha1 = ?")
+
+	tests := []struct {
+		name      string
+		expect    func(mock sqlmock.Sqlmock)
+		wantAdded bool
+		wantID    int64
+		wantErr   bool
+	}{
+		{
+			name: "new file is inserted",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUser(mock, "alice")
+				mock.ExpectQuery(selectCurrent).
+					WithArgs("alice", int64(7), "report.pdf").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectExec(insertFile).
+					WithArgs("alice", "new-sha1", "report.pdf", int64(200), "application/pdf", int64(7)).
+					WillReturnResult(sqlmock.NewResult(42, 1))
+				mock.ExpectCommit()
+			},
+			wantAdded: true,
+			wantID:    42,
+		},
+		{
+			name: "same content is not inserted again",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUser(mock, "alice")
+				mock.ExpectQuery(selectCurrent).
+					WithArgs("alice", int64(7), "report.pdf").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "file_sha1", "file_size", "upload_at"}).
+						AddRow(9, "new-sha1", 200, uploadAt))
+				mock.ExpectRollback()
+			},
+			wantAdded: false,
+			wantID:    9,
+		},
+		{
+			name: "different content keeps old content as a version",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUser(mock, "alice")
+				mock.ExpectQuery(selectCurrent).
+					WithArgs("alice", int64(7), "report.pdf").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "file_sha1", "file_size", "upload_at"}).
+						AddRow(9, "old-sha1", 100, uploadAt))
+				mock.ExpectExec(insertVersion).
+					WithArgs(int64(9), "alice", "old-sha1", int64(100), uploadAt).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateFile).
+					WithArgs("new-sha1", int64(200), "application/pdf", int64(9)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantAdded: true,
+			wantID:    9,
+		},
+		{
+			name: "version insert failure rolls back",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockUser(mock, "alice")
+				mock.ExpectQuery(selectCurrent).
+					WithArgs("alice", int64(7), "report.pdf").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "file_sha1", "file_size", "upload_at"}).
+						AddRow(9, "old-sha1", 100, uploadAt))
+				mock.ExpectExec(insertVersion).WillReturnError(errors.New("disk full"))
+				mock.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown user",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tbl_user WHERE user_name = ? FOR UPDATE")).
+					WithArgs("alice").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			tt.expect(mock)
+
+			uf := &UserFile{UserName: "alice", FileSha1: "new-sha1", FileName: "report.pdf", FileSize: 200, ParentID: 7}
+			added, err := InsertUserFile(context.Background(), uf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InsertUserFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if added != tt.wantAdded || uf.ID != tt.wantID {
+				t.Errorf("InsertUserFile() = (added %v, id %d), want (added %v, id %d)", added, uf.ID, tt.wantAdded, tt.wantID)
+			}
+		})
+	}
+}
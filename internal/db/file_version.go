@@ -0,0 +1,178 @@
+package db
+
+/**
+ * @Description: 文件历史版本
+ * 当前版本保存在 tbl_user_file，之前的版本保存在 tbl_file_version，
+ * 各版本都引用去重后的 files/<sha1> 对象，不额外占用存储空间
+ */
+
+import (
+	"context"
+	"database/sql"
+	cacheRedis "file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/store"
+	"log"
+	"time"
+)
+
+type FileVersion struct {
+	ID         int64     `json:"id"`
+	UserFileID int64     `json:"user_file_id"`
+	FileSha1   string    `json:"file_sha1"`
+	FileSize   int64     `json:"file_size"`
+	CreateAt   time.Time `json:"create_at"`
+}
+
+const fileVersionColumns = "id, user_file_id, file_sha1, file_size, create_at"
+
+func scanFileVersions(rows *sql.Rows) ([]*FileVersion, error) {
+	defer rows.Close()
+
+	var versions []*FileVersion
+	for rows.Next() {
+		v := &FileVersion{}
+		if err := rows.Scan(&v.ID, &v.UserFileID, &v.FileSha1, &v.FileSize, &v.CreateAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// 列出文件的历史版本（新的在前）
+func ListFileVersions(ctx context.Context, userFileID int64) ([]*FileVersion, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+fileVersionColumns+" FROM tbl_file_version WHERE user_file_id = ? ORDER BY create_at DESC, id DESC",
+		userFileID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileVersions(rows)
+}
+
+// 查询文件的某个历史版本
+func GetFileVersion(ctx context.Context, userFileID, id int64) (*FileVersion, error) {
+	v := &FileVersion{}
+	err := DB.QueryRowContext(ctx,
+		"SELECT "+fileVersionColumns+" FROM tbl_file_version WHERE id = ? AND user_file_id = ?",
+		id, userFileID,
+	).Scan(&v.ID, &v.UserFileID, &v.FileSha1, &v.FileSize, &v.CreateAt)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 把历史版本恢复为当前版本：当前内容转为一个新的历史版本，被恢复的版本从历史中移除
+// 版本不存在时返回 sql.ErrNoRows
+func RestoreFileVersion(ctx context.Context, uf *UserFile, versionID int64) (*FileVersion, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	v := &FileVersion{}
+	if err := tx.QueryRowContext(ctx,
+		"SELECT "+fileVersionColumns+" FROM tbl_file_version WHERE id = ? AND user_file_id = ? FOR UPDATE",
+		versionID, uf.ID,
+	).Scan(&v.ID, &v.UserFileID, &v.FileSha1, &v.FileSize, &v.CreateAt); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tbl_file_version (user_file_id, user_name, file_sha1, file_size, create_at) VALUES (?, ?, ?, ?, ?)",
+		uf.ID, uf.UserName, uf.FileSha1, uf.FileSize, uf.UploadAt,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_file SET file_sha1 = ?, file_size = ?, upload_at = NOW() WHERE id = ? AND status = 0",
+		v.FileSha1, v.FileSize, uf.ID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tbl_file_version WHERE id = ?", v.ID); err != nil {
+		return nil, err
+	}
+	return v, tx.Commit()
+}
+
+// 只保留最新的 keep 个历史版本，返回被删除的版本
+func PruneFileVersions(ctx context.Context, userFileID int64, keep int) ([]*FileVersion, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+fileVersionColumns+" FROM tbl_file_version WHERE user_file_id = ? ORDER BY create_at DESC, id DESC LIMIT 18446744073709551615 OFFSET ?",
+		userFileID, keep)
+	if err != nil {
+		return nil, err
+	}
+	return deleteFileVersions(ctx, rows)
+}
+
+// 删除回收站中某个文件的全部历史版本（彻底删除文件时调用），返回被删除的版本
+func PurgeFileVersions(ctx context.Context, username string, userFileID int64) ([]*FileVersion, error) {
+	rows, err := DB.QueryContext(ctx,
+		`SELECT v.id, v.user_file_id, v.file_sha1, v.file_size, v.create_at FROM tbl_file_version v
+		JOIN tbl_user_file f ON f.id = v.user_file_id
+		WHERE f.id = ? AND f.user_name = ? AND f.status = 1`,
+		userFileID, username)
+	if err != nil {
+		return nil, err
+	}
+	return deleteFileVersions(ctx, rows)
+}
+
+func deleteFileVersions(ctx context.Context, rows *sql.Rows) ([]*FileVersion, error) {
+	versions, err := scanFileVersions(rows)
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+
+	ids := make([]int64, len(versions))
+	for i, v := range versions {
+		ids[i] = v.ID
+	}
+	placeholders, args := inArgs(ids)
+	if _, err := DB.ExecContext(ctx, "DELETE FROM tbl_file_version WHERE id IN ("+placeholders+")", args...); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// 文件内容是否仍被引用（任意用户的正常或回收站文件，或历史版本）
+func IsFileReferenced(ctx context.Context, filehash string) (bool, error) {
+	var cnt int
+	err := DB.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(1) FROM tbl_user_file WHERE file_sha1 = ? AND status IN (0, 1))
+		+ (SELECT COUNT(1) FROM tbl_file_version WHERE file_sha1 = ?)`,
+		filehash, filehash,
+	).Scan(&cnt)
+	return cnt > 0, err
+}
+
+// ReleaseFileObject 文件内容不再被任何文件或历史版本引用时，删除存储对象和元信息
+// 处理历史版本的接口和延迟删除消费者共用
+func ReleaseFileObject(ctx context.Context, fileHash string) {
+	// 与上传共用文件哈希锁，避免删除正在被重新上传复用的对象
+	lock := cacheRedis.NewLock(ctx, "lock:"+fileHash, time.Minute)
+	locked, err := lock.TryLock()
+	if err != nil || !locked {
+		return
+	}
+	defer lock.Unlock()
+
+	used, err := IsFileReferenced(ctx, fileHash)
+	if err != nil || used {
+		return
+	}
+	fm, err := GetFileMeta(ctx, fileHash)
+	if err != nil || fm == nil {
+		return
+	}
+	if err := store.Backend.RemoveObject(ctx, fm.Location); err != nil {
+		log.Printf("remove file object failed: filehash=%s, err=%v", fileHash, err)
+		return
+	}
+	_ = cacheRedis.DeleteFileMetaCache(ctx, fileHash)
+	_ = PermanentDeleteFileMeta(ctx, fileHash)
+}
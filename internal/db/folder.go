@@ -0,0 +1,304 @@
+package db
+
+/**
+ * @Description: 用户目录表（每个用户一棵目录树，parent_id = 0 表示根目录）
+ * 表上没有 (user_name, parent_id, folder_name) 唯一键（已删除的目录保留原名），
+ * 创建、重命名、移动都在 lockUserFiles 锁内查重后写入
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrFolderNameConflict 目标目录下已有同名目录
+	ErrFolderNameConflict = errors.New("folder already exists")
+	// ErrFolderCycle 目录不能移动到自身或自身的子孙目录下
+	ErrFolderCycle = errors.New("cannot move folder into itself")
+)
+
+// RootFolderID 根目录ID（根目录不落库）
+const RootFolderID int64 = 0
+
+type Folder struct {
+	ID       int64     `json:"id"`
+	UserName string    `json:"user_name"`
+	ParentID int64     `json:"parent_id"`
+	Name     string    `json:"name"`
+	CreateAt time.Time `json:"create_at"`
+	UpdateAt time.Time `json:"update_at"`
+}
+
+const folderColumns = "id, user_name, parent_id, folder_name, create_at, update_at"
+
+func scanFolder(row interface{ Scan(...interface{}) error }) (*Folder, error) {
+	f := &Folder{}
+	if err := row.Scan(&f.ID, &f.UserName, &f.ParentID, &f.Name, &f.CreateAt, &f.UpdateAt); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkFolderName 在锁内确认父目录存在且其下没有同名目录（excludeID 为目录自身，新建时传 0）
+// 父目录不存在返回 sql.ErrNoRows，重名返回 ErrFolderNameConflict
+func checkFolderName(ctx context.Context, tx *sql.Tx, username string, parentID int64, name string, excludeID int64) error {
+	if parentID != RootFolderID {
+		var id int64
+		if err := tx.QueryRowContext(ctx,
+			"SELECT id FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0",
+			parentID, username,
+		).Scan(&id); err != nil {
+			return err
+		}
+	}
+	var cnt int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM tbl_user_folder WHERE user_name = ? AND parent_id = ? AND folder_name = ? AND status = 0 AND id <> ?",
+		username, parentID, name, excludeID,
+	).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return ErrFolderNameConflict
+	}
+	return nil
+}
+
+// lockFolder 在事务中锁定并读取目录（仅正常状态）
+func lockFolder(ctx context.Context, tx *sql.Tx, username string, id int64) (*Folder, error) {
+	return scanFolder(tx.QueryRowContext(ctx,
+		"SELECT "+folderColumns+" FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0 FOR UPDATE",
+		id, username,
+	))
+}
+
+// 创建目录，父目录下已有同名目录时返回 ErrFolderNameConflict
+func CreateFolder(ctx context.Context, username string, parentID int64, name string) (int64, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return 0, err
+	}
+	if err := checkFolderName(ctx, tx, username, parentID, name, 0); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO tbl_user_folder (user_name, parent_id, folder_name) VALUES (?, ?, ?)",
+		username, parentID, name,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// 根据ID获取目录（仅正常状态）
+func GetFolder(ctx context.Context, username string, id int64) (*Folder, error) {
+	return scanFolder(DB.QueryRowContext(ctx,
+		"SELECT "+folderColumns+" FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0",
+		id, username,
+	))
+}
+
+// 获取父目录下指定名称的子目录，不存在时返回 sql.ErrNoRows
+func GetChildFolder(ctx context.Context, username string, parentID int64, name string) (*Folder, error) {
+	return scanFolder(DB.QueryRowContext(ctx,
+		"SELECT "+folderColumns+" FROM tbl_user_folder WHERE user_name = ? AND parent_id = ? AND folder_name = ? AND status = 0 LIMIT 1",
+		username, parentID, name,
+	))
+}
+
+// 列出父目录下的子目录
+func ListChildFolders(ctx context.Context, username string, parentID int64) ([]*Folder, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+folderColumns+" FROM tbl_user_folder WHERE user_name = ? AND parent_id = ? AND status = 0 ORDER BY folder_name",
+		username, parentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []*Folder
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// 重命名目录，返回重命名前的目录；目录不存在返回 sql.ErrNoRows，重名返回 ErrFolderNameConflict
+func RenameFolder(ctx context.Context, username string, id int64, name string) (*Folder, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return nil, err
+	}
+	folder, err := lockFolder(ctx, tx, username, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFolderName(ctx, tx, username, folder.ParentID, name, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_folder SET folder_name = ? WHERE id = ?",
+		name, id,
+	); err != nil {
+		return nil, err
+	}
+	return folder, tx.Commit()
+}
+
+// 移动目录到新的父目录，返回移动前的目录
+// 目录或目标目录不存在返回 sql.ErrNoRows，形成环返回 ErrFolderCycle，重名返回 ErrFolderNameConflict
+func MoveFolder(ctx context.Context, username string, id, parentID int64) (*Folder, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return nil, err
+	}
+	folder, err := lockFolder(ctx, tx, username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不能移动到自身或自身的子孙目录下：从目标目录向上走，遇到自身即为环
+	for cur := parentID; cur != RootFolderID; {
+		if cur == id {
+			return nil, ErrFolderCycle
+		}
+		if err := tx.QueryRowContext(ctx,
+			"SELECT parent_id FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0",
+			cur, username,
+		).Scan(&cur); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkFolderName(ctx, tx, username, parentID, folder.Name, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_folder SET parent_id = ? WHERE id = ?",
+		parentID, id,
+	); err != nil {
+		return nil, err
+	}
+	return folder, tx.Commit()
+}
+
+// 获取目录及其全部子孙目录ID（按层级广度优先）
+func folderTreeIDs(ctx context.Context, tx *sql.Tx, username string, id int64) ([]int64, error) {
+	ids := []int64{id}
+	level := []int64{id}
+	for len(level) > 0 {
+		placeholders, args := inArgs(level)
+		rows, err := tx.QueryContext(ctx,
+			"SELECT id FROM tbl_user_folder WHERE user_name = ? AND status = 0 AND parent_id IN ("+placeholders+")",
+			append([]interface{}{username}, args...)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		var next []int64
+		for rows.Next() {
+			var childID int64
+			if err := rows.Scan(&childID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			next = append(next, childID)
+		}
+		rows.Close()
+		ids = append(ids, next...)
+		level = next
+	}
+	return ids, nil
+}
+
+// 删除目录树（软删）：目录及全部子孙目录标记删除，目录下的文件一并移入回收站
+// 返回被删除的目录ID和移入回收站的文件；目录不存在返回 sql.ErrNoRows
+func DeleteFolderTree(ctx context.Context, username string, id int64) ([]int64, []*UserFile, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// 和创建、移动互斥，子孙目录在锁内遍历，删除期间不会有目录移入
+	if err := lockUserFiles(ctx, tx, username); err != nil {
+		return nil, nil, err
+	}
+	if _, err := lockFolder(ctx, tx, username, id); err != nil {
+		return nil, nil, err
+	}
+	ids, err := folderTreeIDs(ctx, tx, username, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	placeholders, args := inArgs(ids)
+	userArgs := append([]interface{}{username}, args...)
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+userFileColumns+" FROM tbl_user_file WHERE user_name = ? AND status = 0 AND parent_id IN ("+placeholders+")",
+		userArgs...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := scanUserFiles(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_file SET status = 1 WHERE user_name = ? AND status = 0 AND parent_id IN ("+placeholders+")",
+		userArgs...,
+	); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_folder SET status = 1 WHERE user_name = ? AND status = 0 AND id IN ("+placeholders+")",
+		userArgs...,
+	); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return ids, files, nil
+}
+
+// inArgs 生成 IN (?, ?, ...) 的占位符和参数
+func inArgs(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
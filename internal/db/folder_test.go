@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var (
+	checkParentSQL = regexp.QuoteMeta("SELECT id FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0")
+	countNameSQL   = regexp.QuoteMeta("SELECT COUNT(*) FROM tbl_user_folder WHERE user_name = ? AND parent_id = ? AND folder_name = ?")
+	lockFolderSQL  = regexp.QuoteMeta("SELECT " + folderColumns + " FROM tbl_user_folder WHERE id = ? AND user_name = ? AND status = 0 FOR UPDATE")
+	parentOfSQL    = regexp.QuoteMeta("SELECT parent_id FROM tbl_user_folder WHERE id = ?")
+)
+
+func folderRow(id, parentID int64, name string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "user_name", "parent_id", "folder_name", "create_at", "update_at"}).
+		AddRow(id, "alice", parentID, name, now, now)
+}
+
+func TestCreateFolder(t *testing.T) {
+	tests := []struct {
+		name     string
+		parentID int64
+		expect   func(mock sqlmock.Sqlmock)
+		wantID   int64
+		wantErr  error
+	}{
+		{
+			name:     "created under root",
+			parentID: RootFolderID,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(countNameSQL).WithArgs("alice", RootFolderID, "docs", int64(0)).
+					WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tbl_user_folder")).
+					WithArgs("alice", RootFolderID, "docs").
+					WillReturnResult(sqlmock.NewResult(5, 1))
+				mock.ExpectCommit()
+			},
+			wantID: 5,
+		},
+		{
+			name:     "name taken",
+			parentID: 3,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(checkParentSQL).WithArgs(int64(3), "alice").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectQuery(countNameSQL).WithArgs("alice", int64(3), "docs", int64(0)).
+					WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrFolderNameConflict,
+		},
+		{
+			name:     "parent deleted meanwhile",
+			parentID: 3,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(checkParentSQL).WithArgs(int64(3), "alice").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			mock.ExpectBegin()
+			expectLockUser(mock, "alice")
+			tt.expect(mock)
+
+			id, err := CreateFolder(context.Background(), "alice", tt.parentID, "docs")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateFolder() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantID {
+				t.Errorf("CreateFolder() id = %d, want %d", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMoveFolder(t *testing.T) {
+	tests := []struct {
+		name     string
+		parentID int64
+		expect   func(mock sqlmock.Sqlmock)
+		wantErr  error
+	}{
+		{
+			name:     "moved into sibling",
+			parentID: 2,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(parentOfSQL).WithArgs(int64(2), "alice").
+					WillReturnRows(sqlmock.NewRows([]string{"parent_id"}).AddRow(RootFolderID))
+				mock.ExpectQuery(checkParentSQL).WithArgs(int64(2), "alice").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery(countNameSQL).WithArgs("alice", int64(2), "a", int64(1)).
+					WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE tbl_user_folder SET parent_id = ? WHERE id = ?")).
+					WithArgs(int64(2), int64(1)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:     "into itself",
+			parentID: 1,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectRollback()
+			},
+			wantErr: ErrFolderCycle,
+		},
+		{
+			name:     "into own descendant",
+			parentID: 4,
+			expect: func(mock sqlmock.Sqlmock) {
+				// 4 -> 3 -> 1
+				mock.ExpectQuery(parentOfSQL).WithArgs(int64(4), "alice").
+					WillReturnRows(sqlmock.NewRows([]string{"parent_id"}).AddRow(3))
+				mock.ExpectQuery(parentOfSQL).WithArgs(int64(3), "alice").
+					WillReturnRows(sqlmock.NewRows([]string{"parent_id"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrFolderCycle,
+		},
+		{
+			name:     "target has folder with same name",
+			parentID: RootFolderID,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(countNameSQL).WithArgs("alice", RootFolderID, "a", int64(1)).
+					WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrFolderNameConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			mock.ExpectBegin()
+			expectLockUser(mock, "alice")
+			mock.ExpectQuery(lockFolderSQL).WithArgs(int64(1), "alice").WillReturnRows(folderRow(1, RootFolderID, "a"))
+			tt.expect(mock)
+
+			_, err := MoveFolder(context.Background(), "alice", 1, tt.parentID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MoveFolder() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package db
+
+/**
+ * @Description: OIDC 外部账户关联
+ */
+
+import (
+	"context"
+)
+
+// 根据提供方和 sub 查询关联的本地用户名，未关联返回 sql.ErrNoRows
+func GetOIDCLinkedUser(ctx context.Context, issuer, subject string) (string, error) {
+	var username string
+	err := DB.QueryRowContext(ctx,
+		"SELECT user_name FROM tbl_user_oidc WHERE issuer = ? AND subject = ? LIMIT 1",
+		issuer, subject).Scan(&username)
+	return username, err
+}
+
+// 关联外部账户到已有用户
+func CreateOIDCLink(ctx context.Context, username, issuer, subject, email string) error {
+	_, err := DB.ExecContext(ctx,
+		"INSERT INTO tbl_user_oidc (user_name, issuer, subject, email) VALUES (?, ?, ?, ?)",
+		username, issuer, subject, email)
+	return err
+}
+
+// 创建本地用户并关联外部账户
+func ProvisionOIDCUser(ctx context.Context, username, hashedPwd, email string, emailValidated bool, issuer, subject string) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tbl_user (user_name, user_pwd, email, email_validated) VALUES (?, ?, ?, ?)",
+		username, hashedPwd, email, emailValidated); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tbl_user_oidc (user_name, issuer, subject, email) VALUES (?, ?, ?, ?)",
+		username, issuer, subject, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package db
+
+/**
+ * @Description: 套餐与存储配额
+ * 用户的配额优先取 tbl_user.quota，未单独设置时取所属套餐的配额；配额为 0 表示不限
+ * 用量按 tbl_user_file.file_size 统计，回收站中的文件在彻底删除前仍占用配额，历史版本同样占用配额
+ */
+
+import (
+	"context"
+	"database/sql"
+	"file-storage-linhe/config"
+	"time"
+)
+
+type Plan struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Quota    int64     `json:"quota"`
+	CreateAt time.Time `json:"create_at"`
+	UpdateAt time.Time `json:"update_at"`
+}
+
+// 列出全部套餐
+func ListPlans(ctx context.Context) ([]*Plan, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, quota, create_at, update_at FROM tbl_plan ORDER BY quota, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []*Plan
+	for rows.Next() {
+		p := &Plan{}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Quota, &p.CreateAt, &p.UpdateAt); err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// 新增套餐或修改已有套餐的配额
+func UpsertPlan(ctx context.Context, name string, quota int64) error {
+	_, err := DB.ExecContext(ctx,
+		"INSERT INTO tbl_plan (name, quota) VALUES (?, ?) ON DUPLICATE KEY UPDATE quota = VALUES(quota)",
+		name, quota)
+	return err
+}
+
+// 套餐是否存在
+func PlanExists(ctx context.Context, name string) (bool, error) {
+	var n int
+	err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM tbl_plan WHERE name = ?", name).Scan(&n)
+	return n > 0, err
+}
+
+// 修改用户套餐，quota 为 nil 时使用套餐配额，否则为该用户单独设置配额
+func SetUserPlan(ctx context.Context, username, plan string, quota *int64) error {
+	_, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET plan = ?, quota = ? WHERE user_name = ? AND status <> ?",
+		plan, quota, username, UserStatusDeleted)
+	return err
+}
+
+// 查询用户的套餐和生效的配额
+func GetUserQuota(ctx context.Context, username string) (string, int64, error) {
+	var plan string
+	var quota sql.NullInt64
+	err := DB.QueryRowContext(ctx,
+		`SELECT u.plan, COALESCE(u.quota, p.quota) FROM tbl_user u
+		LEFT JOIN tbl_plan p ON p.name = u.plan
+		WHERE u.user_name = ? LIMIT 1`,
+		username).Scan(&plan, &quota)
+	if err != nil {
+		return "", 0, err
+	}
+	if !quota.Valid {
+		// 套餐已不存在
+		return plan, config.DefaultStorageQuota, nil
+	}
+	return plan, quota.Int64, nil
+}
+
+// 统计用户已用存储（正常和回收站中的文件，以及它们的历史版本）
+func GetUserStorageUsage(ctx context.Context, username string) (int64, error) {
+	var used int64
+	err := DB.QueryRowContext(ctx,
+		`SELECT (SELECT IFNULL(SUM(file_size), 0) FROM tbl_user_file WHERE user_name = ? AND status IN (0, 1))
+		+ (SELECT IFNULL(SUM(file_size), 0) FROM tbl_file_version WHERE user_name = ?)`,
+		username, username).Scan(&used)
+	return used, err
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setupMockDB 用 sqlmock 替换 DB，测试结束时检查全部预期都已执行
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := DB
+	DB = mockDB
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet sql expectations: %v", err)
+		}
+		_ = mockDB.Close()
+		DB = old
+	})
+	return mock
+}
+
+// expectLockUser 预期 lockUserFiles 对用户行加锁
+func expectLockUser(mock sqlmock.Sqlmock, username string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tbl_user WHERE user_name = ? FOR UPDATE")).
+		WithArgs(username).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
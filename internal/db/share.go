@@ -0,0 +1,119 @@
+package db
+
+/**
+ * @Description: 分享链接
+ */
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Share struct {
+	ID            int64      `json:"id"`
+	Token         string     `json:"token"`
+	UserName      string     `json:"-"`
+	UserFileID    int64      `json:"user_file_id"`
+	FileSha1      string     `json:"file_sha1"`
+	FileName      string     `json:"file_name"`
+	HasPassword   bool       `json:"has_password"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	MaxDownloads  int        `json:"max_downloads"`
+	DownloadCount int        `json:"download_count"`
+	PreviewOnly   bool       `json:"preview_only"`
+	CreateAt      time.Time  `json:"create_at"`
+	Password      string     `json:"-"` // 访问密码的 bcrypt 哈希，空为无密码
+}
+
+// 分享记录和所指向的用户文件一起查出，文件已删除的分享视为无效
+const shareColumns = `s.id, s.token, s.user_name, s.user_file_id, f.file_sha1, f.file_name, s.password,
+	s.expires_at, s.max_downloads, s.download_count, s.preview_only, s.create_at`
+
+const shareJoin = ` FROM tbl_share s JOIN tbl_user_file f ON f.id = s.user_file_id AND f.status = 0`
+
+func scanShare(scanner interface{ Scan(...interface{}) error }) (*Share, error) {
+	s := &Share{}
+	var expiresAt sql.NullTime
+	if err := scanner.Scan(&s.ID, &s.Token, &s.UserName, &s.UserFileID, &s.FileSha1, &s.FileName, &s.Password,
+		&expiresAt, &s.MaxDownloads, &s.DownloadCount, &s.PreviewOnly, &s.CreateAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		s.ExpiresAt = &expiresAt.Time
+	}
+	s.HasPassword = s.Password != ""
+	return s, nil
+}
+
+// 创建分享链接
+func CreateShare(ctx context.Context, s *Share) error {
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO tbl_share (token, user_name, user_file_id, password, expires_at, max_downloads, preview_only)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.Token, s.UserName, s.UserFileID, s.Password, s.ExpiresAt, s.MaxDownloads, s.PreviewOnly)
+	if err != nil {
+		return err
+	}
+	s.ID, err = res.LastInsertId()
+	s.HasPassword = s.Password != ""
+	s.CreateAt = time.Now()
+	return err
+}
+
+// 根据 token 查询有效的分享（未取消、文件未删除、分享者账户可用）
+// 是否过期、次数是否用完由调用方判断，以便返回不同的提示
+func GetShareByToken(ctx context.Context, token string) (*Share, error) {
+	row := DB.QueryRowContext(ctx,
+		"SELECT "+shareColumns+shareJoin+`
+		WHERE s.token = ? AND s.status = 0
+		AND s.user_name IN (SELECT user_name FROM tbl_user WHERE status IN (?, ?)) LIMIT 1`,
+		token, UserStatusEnabled, UserStatusLocked)
+	return scanShare(row)
+}
+
+// 列出用户创建的有效分享
+func ListShares(ctx context.Context, username string) ([]*Share, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+shareColumns+shareJoin+" WHERE s.user_name = ? AND s.status = 0 ORDER BY s.id DESC",
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*Share
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// 取消分享，返回是否存在该分享
+func RevokeShare(ctx context.Context, username string, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_share SET status = 1 WHERE id = ? AND user_name = ? AND status = 0",
+		id, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 访问次数加一，次数已用完时返回 false（条件更新，并发访问不会超出上限）
+func IncrShareDownload(ctx context.Context, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		`UPDATE tbl_share SET download_count = download_count + 1
+		WHERE id = ? AND status = 0 AND (max_downloads = 0 OR download_count < max_downloads)`,
+		id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
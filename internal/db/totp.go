@@ -0,0 +1,233 @@
+package db
+
+/**
+ * @Description: 两步验证（TOTP）与恢复码
+ * TOTP 密钥用 TOTP_SECRET_KEY 加密后存储（"enc:" + 密文），数据库泄露时无法直接生成口令
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/util"
+	"fmt"
+	"strings"
+)
+
+// 加密后的 TOTP 密钥前缀，没有前缀的是升级前保存的明文
+const totpSecretPrefix = "enc:"
+
+// ErrTOTPKeyNotConfigured 未配置 TOTP_SECRET_KEY，无法读写 TOTP 密钥
+var ErrTOTPKeyNotConfigured = errors.New("TOTP_SECRET_KEY is not set")
+
+var totpKey []byte
+
+// InitTOTPKey 加载 TOTP 密钥的加密密钥
+// 未配置时返回 ErrTOTPKeyNotConfigured，服务照常启动，只是两步验证的绑定和口令校验不可用
+func InitTOTPKey() error {
+	if config.TOTPSecretKey == "" {
+		return ErrTOTPKeyNotConfigured
+	}
+	key, err := util.ParseAESKey(config.TOTPSecretKey)
+	if err != nil {
+		return fmt.Errorf("invalid TOTP_SECRET_KEY: %w", err)
+	}
+	totpKey = key
+	return nil
+}
+
+// TOTPKeyConfigured 是否已加载 TOTP 密钥的加密密钥
+func TOTPKeyConfigured() bool {
+	return totpKey != nil
+}
+
+func encryptTOTPSecret(secret string) (string, error) {
+	if totpKey == nil {
+		return "", ErrTOTPKeyNotConfigured
+	}
+	enc, err := util.EncryptString(totpKey, secret)
+	if err != nil {
+		return "", err
+	}
+	return totpSecretPrefix + enc, nil
+}
+
+func decryptTOTPSecret(stored string) (string, error) {
+	enc, ok := strings.CutPrefix(stored, totpSecretPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if totpKey == nil {
+		return "", ErrTOTPKeyNotConfigured
+	}
+	return util.DecryptString(totpKey, enc)
+}
+
+type UserTOTP struct {
+	UserName string
+	Secret   string
+	Enabled  bool
+}
+
+// 获取用户的 TOTP 配置，未配置返回 sql.ErrNoRows
+func GetUserTOTP(ctx context.Context, username string) (*UserTOTP, error) {
+	t := &UserTOTP{}
+	err := DB.QueryRowContext(ctx,
+		"SELECT user_name, secret, enabled FROM tbl_user_totp WHERE user_name = ? LIMIT 1",
+		username).Scan(&t.UserName, &t.Secret, &t.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	if t.Secret, err = decryptTOTPSecret(t.Secret); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// 是否已启用两步验证（不读取密钥，未配置 TOTP_SECRET_KEY 时也可用）
+func IsTOTPEnabled(ctx context.Context, username string) (bool, error) {
+	var enabled bool
+	err := DB.QueryRowContext(ctx,
+		"SELECT enabled FROM tbl_user_totp WHERE user_name = ? LIMIT 1",
+		username).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// 保存待确认的 TOTP 密钥（已启用的配置不会被覆盖）
+func SavePendingTOTP(ctx context.Context, username, secret string) (bool, error) {
+	secret, err := encryptTOTPSecret(secret)
+	if err != nil {
+		return false, err
+	}
+	res, err := DB.ExecContext(ctx,
+		`INSERT INTO tbl_user_totp (user_name, secret, enabled) VALUES (?, ?, 0)
+		ON DUPLICATE KEY UPDATE secret = IF(enabled = 0, VALUES(secret), secret)`,
+		username, secret)
+	if err != nil {
+		return false, err
+	}
+	// 已启用时 secret 不变，affected rows 为 0
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 加密升级前以明文保存的 TOTP 密钥（服务启动时执行）
+func EncryptLegacyTOTPSecrets(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT user_name, secret FROM tbl_user_totp WHERE secret NOT LIKE 'enc:%'")
+	if err != nil {
+		return err
+	}
+	legacy := map[string]string{}
+	for rows.Next() {
+		var name, secret string
+		if err := rows.Scan(&name, &secret); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[name] = secret
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, secret := range legacy {
+		enc, err := encryptTOTPSecret(secret)
+		if err != nil {
+			return err
+		}
+		// 只替换仍是旧明文的记录，避免覆盖期间重新绑定的密钥
+		if _, err := DB.ExecContext(ctx,
+			"UPDATE tbl_user_totp SET secret = ? WHERE user_name = ? AND secret = ?",
+			enc, name, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 启用两步验证并替换全部恢复码
+func EnableTOTP(ctx context.Context, username string, codeHashes []string) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE tbl_user_totp SET enabled = 1, enabled_at = NOW() WHERE user_name = ? AND enabled = 0",
+		username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, username, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, username string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM tbl_user_recovery_code WHERE user_name = ?", username); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO tbl_user_recovery_code (user_name, code_hash) VALUES (?, ?)",
+			username, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 关闭两步验证，同时删除恢复码
+func DisableTOTP(ctx context.Context, username string) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM tbl_user_totp WHERE user_name = ?", username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM tbl_user_recovery_code WHERE user_name = ?", username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 使用恢复码（每个恢复码只能使用一次），返回是否使用成功
+func UseRecoveryCode(ctx context.Context, username, codeHash string) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user_recovery_code SET used_at = NOW() WHERE user_name = ? AND code_hash = ? AND used_at IS NULL",
+		username, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 剩余可用恢复码数量
+func CountRecoveryCodes(ctx context.Context, username string) (int, error) {
+	var n int
+	err := DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM tbl_user_recovery_code WHERE user_name = ? AND used_at IS NULL",
+		username).Scan(&n)
+	return n, err
+}
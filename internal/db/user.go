@@ -6,20 +6,43 @@ package db
 
 import (
 	"context"
+	"file-storage-linhe/config"
+	"strings"
 	"time"
 )
 
+// 账户状态（tbl_user.status）
+const (
+	UserStatusEnabled  = 0 // 启用
+	UserStatusDisabled = 1 // 禁用
+	UserStatusLocked   = 2 // 登录失败次数过多被临时锁定
+	UserStatusDeleted  = 3 // 已被管理员删除（标记删除）
+)
+
+// 用户角色（tbl_user.role）
+const (
+	RoleUser    = "user"    // 普通用户
+	RoleAdmin   = "admin"   // 管理员
+	RoleAuditor = "auditor" // 审计员，只能查看
+)
+
 type User struct {
-	UserName string
-	UserPwd  string
-	SignupAt time.Time
+	UserName       string
+	UserPwd        string
+	Email          string
+	EmailValidated bool
+	Profile        string
+	SignupAt       time.Time
+	LastActive     time.Time
+	Status         int
+	Role           string
 }
 
 // 用户注册
-func UserSingup(ctx context.Context, username, userpwd string) error {
+func UserSingup(ctx context.Context, username, userpwd, email string) error {
 	_, err := DB.ExecContext(ctx,
-		"INSERT INTO tbl_user (user_name, user_pwd) VALUES (?, ?)",
-		username, userpwd)
+		"INSERT INTO tbl_user (user_name, user_pwd, email) VALUES (?, ?, ?)",
+		username, userpwd, email)
 	return err
 }
 
@@ -27,8 +50,8 @@ func UserSingup(ctx context.Context, username, userpwd string) error {
 func GetUserByNameWithPwd(ctx context.Context, username string) (*User, error) {
 	u := &User{}
 	err := DB.QueryRowContext(ctx,
-		"SELECT user_name, user_pwd FROM tbl_user WHERE user_name = ? LIMIT 1",
-		username).Scan(&u.UserName, &u.UserPwd)
+		"SELECT user_name, user_pwd, IFNULL(email, ''), IFNULL(email_validated, 0), status, role FROM tbl_user WHERE user_name = ? LIMIT 1",
+		username).Scan(&u.UserName, &u.UserPwd, &u.Email, &u.EmailValidated, &u.Status, &u.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -40,11 +63,185 @@ func GetUserInfo(ctx context.Context, username string) (*User, error) {
 	u := &User{}
 
 	err := DB.QueryRowContext(ctx,
-		`SELECT user_name, signup_at FROM tbl_user WHERE user_name = ? LIMIT 1`,
+		`SELECT user_name, signup_at, IFNULL(email, ''), IFNULL(email_validated, 0), IFNULL(profile, '')
+		FROM tbl_user WHERE user_name = ? LIMIT 1`,
 		username,
-	).Scan(&u.UserName, &u.SignupAt)
+	).Scan(&u.UserName, &u.SignupAt, &u.Email, &u.EmailValidated, &u.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// 锁定账户（仅对启用状态的账户生效，禁用的账户保持禁用）
+func LockUser(ctx context.Context, username string) error {
+	_, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET status = ? WHERE user_name = ? AND status = ?",
+		UserStatusLocked, username, UserStatusEnabled)
+	return err
+}
+
+// 解除锁定，返回账户是否处于锁定状态
+func UnlockUser(ctx context.Context, username string) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET status = ? WHERE user_name = ? AND status = ?",
+		UserStatusEnabled, username, UserStatusLocked)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 根据邮箱查询用户
+func GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	u := &User{}
+	err := DB.QueryRowContext(ctx,
+		"SELECT user_name, user_pwd, IFNULL(email, ''), IFNULL(email_validated, 0), status, role FROM tbl_user WHERE email = ? LIMIT 1",
+		email).Scan(&u.UserName, &u.UserPwd, &u.Email, &u.EmailValidated, &u.Status, &u.Role)
 	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
+
+// 修改密码（传入已加密的密码）
+func UpdateUserPassword(ctx context.Context, username, hashedPwd string) error {
+	_, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET user_pwd = ? WHERE user_name = ?",
+		hashedPwd, username)
+	return err
+}
+
+// 更新资料，邮箱变更时重置验证状态
+func UpdateUserProfile(ctx context.Context, username, email, profile string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE tbl_user SET
+			email_validated = IF(IFNULL(email, '') = ?, email_validated, 0),
+			email = ?, profile = ?
+		WHERE user_name = ?`,
+		email, email, profile, username)
+	return err
+}
+
+// 标记邮箱已验证（仅当邮箱仍是发送验证邮件时的地址），返回是否更新
+func SetEmailValidated(ctx context.Context, username, email string) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET email_validated = 1 WHERE user_name = ? AND email = ? AND email_validated = 0",
+		username, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 邮箱是否已被其他用户使用
+func EmailInUse(ctx context.Context, email, exceptUser string) (bool, error) {
+	var n int
+	err := DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM tbl_user WHERE email = ? AND user_name <> ?",
+		email, exceptUser).Scan(&n)
+	return n > 0, err
+}
+
+// 查询用户角色
+func GetUserRole(ctx context.Context, username string) (string, error) {
+	var role string
+	err := DB.QueryRowContext(ctx,
+		"SELECT role FROM tbl_user WHERE user_name = ? AND status <> ? LIMIT 1",
+		username, UserStatusDeleted).Scan(&role)
+	return role, err
+}
+
+// 修改用户角色
+func SetUserRole(ctx context.Context, username, role string) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET role = ? WHERE user_name = ? AND status <> ?",
+		role, username, UserStatusDeleted)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 修改账户状态（已删除的账户不能再修改），返回是否修改
+func SetUserStatus(ctx context.Context, username string, status int) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user SET status = ? WHERE user_name = ? AND status <> ?",
+		status, username, UserStatusDeleted)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 把 ADMIN_USERS 中的用户提升为管理员（服务启动时执行，用于初始化第一个管理员）
+func EnsureAdminRoles(ctx context.Context) error {
+	for _, name := range strings.Split(config.AdminUsers, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := DB.ExecContext(ctx,
+			"UPDATE tbl_user SET role = ? WHERE user_name = ? AND role <> ?",
+			RoleAdmin, name, RoleAdmin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 用户列表查询条件
+type UserQuery struct {
+	Keyword string // 用户名或邮箱包含
+	Status  *int
+	Role    string
+	Offset  int
+	Limit   int
+}
+
+// 分页查询用户列表，返回当前页和总数
+func ListUsers(ctx context.Context, q *UserQuery) ([]*User, int, error) {
+	where := "1 = 1"
+	var args []interface{}
+	if q.Keyword != "" {
+		like := "%" + escapeLike(q.Keyword) + "%"
+		where += " AND (user_name LIKE ? OR email LIKE ?)"
+		args = append(args, like, like)
+	}
+	if q.Status != nil {
+		where += " AND status = ?"
+		args = append(args, *q.Status)
+	}
+	if q.Role != "" {
+		where += " AND role = ?"
+		args = append(args, q.Role)
+	}
+
+	var total int
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM tbl_user WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT user_name, IFNULL(email, ''), IFNULL(email_validated, 0), signup_at, last_active, status, role
+		FROM tbl_user WHERE `+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u := &User{}
+		if err := rows.Scan(&u.UserName, &u.Email, &u.EmailValidated, &u.SignupAt, &u.LastActive, &u.Status, &u.Role); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
@@ -0,0 +1,131 @@
+package db
+
+/**
+ * @Description: 用户间共享（把文件或目录授权给指定用户只读或读写）
+ * 目录授权对其下的全部子目录和文件生效；共享者账户被禁用或删除后，其共享一并失效
+ */
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// 共享资源类型
+const (
+	ShareResourceFile   = "file"
+	ShareResourceFolder = "folder"
+)
+
+// 共享权限
+const (
+	SharePermRead      = "read" // 只读：查看、下载
+	SharePermReadWrite = "rw"   // 读写：另外可以向共享目录上传文件
+)
+
+type UserShare struct {
+	ID           int64     `json:"id"`
+	Owner        string    `json:"owner"`
+	Grantee      string    `json:"grantee"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   int64     `json:"resource_id"`
+	Permission   string    `json:"permission"`
+	Name         string    `json:"name"`                // 文件名或目录名
+	FileSha1     string    `json:"file_sha1,omitempty"` // 仅文件
+	FileSize     int64     `json:"file_size,omitempty"` // 仅文件
+	CreateAt     time.Time `json:"create_at"`
+}
+
+// 共享者账户可用（启用或临时锁定）
+const shareOwnerActive = "user_name IN (SELECT user_name FROM tbl_user WHERE status IN (0, 2))"
+
+// 共享记录连同资源名称一起查出，资源已删除的共享不返回
+const userShareSelect = `SELECT g.id, g.user_name, g.grantee, g.resource_type, g.resource_id, g.permission,
+	COALESCE(f.file_name, d.folder_name, ''), IFNULL(f.file_sha1, ''), IFNULL(f.file_size, 0), g.create_at
+	FROM tbl_user_share g
+	LEFT JOIN tbl_user_file f ON g.resource_type = 'file' AND f.id = g.resource_id AND f.status = 0
+	LEFT JOIN tbl_user_folder d ON g.resource_type = 'folder' AND d.id = g.resource_id AND d.status = 0
+	WHERE g.status = 0 AND (f.id IS NOT NULL OR d.id IS NOT NULL) AND g.` + shareOwnerActive
+
+func queryUserShares(ctx context.Context, where string, args ...interface{}) ([]*UserShare, error) {
+	rows, err := DB.QueryContext(ctx, userShareSelect+" AND "+where+" ORDER BY g.id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*UserShare
+	for rows.Next() {
+		s := &UserShare{}
+		if err := rows.Scan(&s.ID, &s.Owner, &s.Grantee, &s.ResourceType, &s.ResourceID, &s.Permission,
+			&s.Name, &s.FileSha1, &s.FileSize, &s.CreateAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// 创建共享，对同一用户重复共享同一资源时更新权限
+func UpsertUserShare(ctx context.Context, s *UserShare) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO tbl_user_share (user_name, grantee, resource_type, resource_id, permission) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE permission = VALUES(permission), status = 0`,
+		s.Owner, s.Grantee, s.ResourceType, s.ResourceID, s.Permission)
+	if err != nil {
+		return err
+	}
+	// ON DUPLICATE KEY 时 LastInsertId 不可靠，重新查一次
+	return DB.QueryRowContext(ctx,
+		"SELECT id, create_at FROM tbl_user_share WHERE resource_type = ? AND resource_id = ? AND grantee = ?",
+		s.ResourceType, s.ResourceID, s.Grantee).Scan(&s.ID, &s.CreateAt)
+}
+
+// 列出用户共享出去的资源
+func ListSharesByOwner(ctx context.Context, owner string) ([]*UserShare, error) {
+	return queryUserShares(ctx, "g.user_name = ?", owner)
+}
+
+// 列出共享给用户的资源（"与我共享"）
+func ListSharesWithUser(ctx context.Context, grantee string) ([]*UserShare, error) {
+	return queryUserShares(ctx, "g.grantee = ?", grantee)
+}
+
+// 撤销共享（只有共享者可以撤销），返回是否存在该共享
+func RevokeUserShare(ctx context.Context, owner string, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx,
+		"UPDATE tbl_user_share SET status = 1 WHERE id = ? AND user_name = ? AND status = 0",
+		id, owner)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 查询用户对某个资源直接获得的权限，没有共享时返回空字符串
+func GetSharePermission(ctx context.Context, grantee, resourceType string, resourceID int64) (string, error) {
+	var perm string
+	err := DB.QueryRowContext(ctx,
+		"SELECT permission FROM tbl_user_share WHERE resource_type = ? AND resource_id = ? AND grantee = ? AND status = 0 AND "+shareOwnerActive,
+		resourceType, resourceID, grantee).Scan(&perm)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return perm, err
+}
+
+// 列出其他用户持有的、内容为 filehash 的文件，仅限向 grantee 共享过资源的用户
+// 用于判断 grantee 能否通过共享访问该文件
+func ListSharedFileCandidates(ctx context.Context, grantee, filehash string) ([]*UserFile, error) {
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+userFileColumns+` FROM tbl_user_file
+		WHERE file_sha1 = ? AND status = 0 AND user_name <> ?
+		AND user_name IN (SELECT user_name FROM tbl_user_share WHERE grantee = ? AND status = 0 AND `+shareOwnerActive+`)`,
+		filehash, grantee, grantee,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserFiles(rows)
+}
@@ -28,8 +28,12 @@ CREATE TABLE `tbl_user` (
   `last_active` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后活跃时间戳',
   `profile` text COMMENT '用户属性',
   `status` int(11) NOT NULL DEFAULT '0' COMMENT '账户状态(启用/禁用/锁定/标记删除等)',
+  `role` varchar(16) NOT NULL DEFAULT 'user' COMMENT '角色(user/admin/auditor)',
+  `plan` varchar(32) NOT NULL DEFAULT 'free' COMMENT '套餐(tbl_plan.name)',
+  `quota` bigint(20) DEFAULT NULL COMMENT '单独设置的存储配额(字节，NULL为使用套餐配额，0为不限)',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_username` (`user_name`),
+  KEY `idx_email` (`email`),
   KEY `idx_status` (`status`)
 ) ENGINE=InnoDB AUTO_INCREMENT=5 DEFAULT CHARSET=utf8mb4;
 
@@ -44,11 +48,31 @@ CREATE TABLE `tbl_user_file` (
   `last_update` datetime DEFAULT CURRENT_TIMESTAMP 
           ON UPDATE CURRENT_TIMESTAMP COMMENT '最后修改时间',
   `status` int(11) NOT NULL DEFAULT '0' COMMENT '文件状态(0正常1已删除2禁用)',
-  UNIQUE KEY `idx_user_file` (`user_name`, `file_sha1`),
+  `parent_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '所在目录ID(0为根目录)',
+  `mime_type` varchar(128) NOT NULL DEFAULT 'application/octet-stream' COMMENT 'MIME类型(按扩展名推断)',
+  KEY `idx_user_file` (`user_name`, `file_sha1`),
   KEY `idx_status` (`status`),
-  KEY `idx_user_id` (`user_name`)
+  KEY `idx_user_id` (`user_name`),
+  KEY `idx_user_parent` (`user_name`, `parent_id`),
+  KEY `idx_user_name_sort` (`user_name`, `file_name`),
+  KEY `idx_user_size_sort` (`user_name`, `file_size`),
+  KEY `idx_user_upload_sort` (`user_name`, `upload_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+-- 创建用户目录表
+CREATE TABLE `tbl_user_folder` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `parent_id` bigint(20) NOT NULL DEFAULT '0' COMMENT '父目录ID(0为根目录)',
+  `folder_name` varchar(256) NOT NULL DEFAULT '' COMMENT '目录名',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `update_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '目录状态(0正常1已删除)',
+  PRIMARY KEY (`id`),
+  KEY `idx_user_parent` (`user_name`, `parent_id`),
+  KEY `idx_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户目录表';
+
 -- 创建操作日志表
 CREATE TABLE `tbl_operation_log` (
   `id` bigint(20) NOT NULL AUTO_INCREMENT,
@@ -66,4 +90,117 @@ CREATE TABLE `tbl_operation_log` (
   KEY `idx_user_name` (`user_name`),
   KEY `idx_operation` (`operation`),
   KEY `idx_created_at` (`created_at`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='操作日志表';
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='操作日志表';
+
+-- 创建两步验证(TOTP)表
+CREATE TABLE `tbl_user_totp` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `secret` varchar(255) NOT NULL DEFAULT '' COMMENT 'TOTP密钥(AES-GCM加密)',
+  `enabled` tinyint(1) NOT NULL DEFAULT 0 COMMENT '是否已启用(0待确认1已启用)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `enabled_at` datetime DEFAULT NULL COMMENT '启用时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='两步验证表';
+
+-- 创建两步验证恢复码表
+CREATE TABLE `tbl_user_recovery_code` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `code_hash` char(64) NOT NULL COMMENT '恢复码sha256',
+  `used_at` datetime DEFAULT NULL COMMENT '使用时间(NULL为未使用)',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_code` (`user_name`, `code_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='两步验证恢复码表';
+
+-- 创建 API Key 表
+CREATE TABLE `tbl_api_key` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `name` varchar(64) NOT NULL DEFAULT '' COMMENT 'Key名称',
+  `key_prefix` varchar(16) NOT NULL DEFAULT '' COMMENT 'Key前缀(用于展示识别)',
+  `key_hash` char(64) NOT NULL COMMENT 'Key的sha256',
+  `scopes` varchar(64) NOT NULL DEFAULT '' COMMENT '权限范围(read,upload,delete)',
+  `expires_at` datetime DEFAULT NULL COMMENT '过期时间(NULL为永不过期)',
+  `last_used_at` datetime DEFAULT NULL COMMENT '最后使用时间',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(0有效1已吊销)',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_key_hash` (`key_hash`),
+  KEY `idx_user_status` (`user_name`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='API Key表';
+
+-- 创建 OIDC 账户关联表
+CREATE TABLE `tbl_user_oidc` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `issuer` varchar(255) NOT NULL COMMENT 'OIDC提供方',
+  `subject` varchar(255) NOT NULL COMMENT '提供方用户ID(sub)',
+  `email` varchar(64) DEFAULT '' COMMENT '关联时提供方返回的邮箱',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '关联时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_issuer_subject` (`issuer`, `subject`),
+  KEY `idx_user_name` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='OIDC账户关联表';
+
+-- 创建分享链接表
+CREATE TABLE `tbl_share` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `token` varchar(64) NOT NULL COMMENT '链接token',
+  `user_name` varchar(64) NOT NULL COMMENT '分享者',
+  `user_file_id` bigint(20) NOT NULL COMMENT '分享的用户文件(tbl_user_file.id)',
+  `password` varchar(256) NOT NULL DEFAULT '' COMMENT '访问密码的bcrypt哈希(空为无密码)',
+  `expires_at` datetime DEFAULT NULL COMMENT '过期时间(NULL为永不过期)',
+  `max_downloads` int(11) NOT NULL DEFAULT '0' COMMENT '最大访问次数(0为不限)',
+  `download_count` int(11) NOT NULL DEFAULT '0' COMMENT '已访问次数',
+  `preview_only` tinyint(1) NOT NULL DEFAULT '0' COMMENT '仅预览(浏览器内打开，不作为附件下载)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(0有效1已取消)',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_token` (`token`),
+  KEY `idx_user_status` (`user_name`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='分享链接表';
+
+-- 创建用户间共享表
+CREATE TABLE `tbl_user_share` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL COMMENT '共享者(资源所有者)',
+  `grantee` varchar(64) NOT NULL COMMENT '被共享的用户',
+  `resource_type` varchar(16) NOT NULL COMMENT '资源类型(file/folder)',
+  `resource_id` bigint(20) NOT NULL COMMENT '资源ID(tbl_user_file.id或tbl_user_folder.id)',
+  `permission` varchar(16) NOT NULL DEFAULT 'read' COMMENT '权限(read只读/rw读写)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '共享时间',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(0有效1已撤销)',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_resource_grantee` (`resource_type`, `resource_id`, `grantee`),
+  KEY `idx_grantee_status` (`grantee`, `status`),
+  KEY `idx_user_status` (`user_name`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户间共享表';
+
+-- 创建文件历史版本表（同一目录下同名文件再次上传时，原内容转为历史版本）
+CREATE TABLE `tbl_file_version` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `user_file_id` bigint(20) NOT NULL COMMENT '所属用户文件(tbl_user_file.id)',
+  `user_name` varchar(64) NOT NULL,
+  `file_sha1` varchar(64) NOT NULL DEFAULT '' COMMENT '该版本的文件hash',
+  `file_size` bigint(20) DEFAULT '0' COMMENT '该版本的文件大小',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '该版本的上传时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_user_file` (`user_file_id`),
+  KEY `idx_file_sha1` (`file_sha1`),
+  KEY `idx_user_name` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='文件历史版本表';
+
+-- 创建套餐表
+CREATE TABLE `tbl_plan` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(32) NOT NULL COMMENT '套餐名',
+  `quota` bigint(20) NOT NULL DEFAULT '0' COMMENT '存储配额(字节，0为不限)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `update_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='套餐表';
+
+INSERT INTO `tbl_plan` (`name`, `quota`) VALUES ('free', 10737418240), ('pro', 1099511627776);
@@ -0,0 +1,136 @@
+package handler
+
+/**
+ * @Description: 文件访问授权
+ * 知道文件哈希不等于拥有文件：读取字节或元信息前，必须确认当前用户持有该文件，
+ * 或者该文件（或其所在目录的任一上级目录）被其他用户共享给了当前用户
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// accessibleFile 查找用户能读取的、内容为 fileHash 的文件：优先自己持有的，其次通过共享可见的，都没有时返回 nil
+// 同一内容有多份时取最早的一份；下载和元信息使用这条记录的文件名，而不是 tbl_file 中首次上传时的名字
+func accessibleFile(ctx context.Context, username, fileHash string) (*db.UserFile, error) {
+	uf, err := db.GetUserFileByHash(ctx, username, fileHash)
+	if err == nil {
+		return uf, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// 其他用户持有相同内容的文件，且共享给了当前用户
+	candidates, err := db.ListSharedFileCandidates(ctx, username, fileHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range candidates {
+		perm, err := sharedFilePermission(ctx, username, f)
+		if err != nil {
+			return nil, err
+		}
+		if perm != "" {
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// sharedFilePermission 用户通过共享对某个文件获得的权限：文件本身的授权或所在目录链上的授权
+func sharedFilePermission(ctx context.Context, grantee string, f *db.UserFile) (string, error) {
+	perm, err := db.GetSharePermission(ctx, grantee, db.ShareResourceFile, f.ID)
+	if err != nil || perm != "" {
+		return perm, err
+	}
+	return sharedFolderPermission(ctx, grantee, f.UserName, f.ParentID)
+}
+
+// sharedFolderPermission 用户通过共享对 owner 的某个目录获得的权限，从该目录逐级向上查找授权
+// 链上任一目录已删除时视为无权限；有多个授权时以离目标最近的为准
+func sharedFolderPermission(ctx context.Context, grantee, owner string, folderID int64) (string, error) {
+	for folderID != db.RootFolderID {
+		f, err := db.GetFolder(ctx, owner, folderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		perm, err := db.GetSharePermission(ctx, grantee, db.ShareResourceFolder, f.ID)
+		if err != nil || perm != "" {
+			return perm, err
+		}
+		folderID = f.ParentID
+	}
+	return "", nil
+}
+
+// authorizeFile 校验当前用户能否访问该文件，返回用户可见的文件记录；不能访问时直接写出响应并返回 false
+// 不区分"文件不存在"和"无权访问"，统一返回 404，避免通过哈希探测文件是否存在
+func authorizeFile(w http.ResponseWriter, r *http.Request, username, fileHash string) (*db.UserFile, bool) {
+	uf, err := accessibleFile(r.Context(), username, fileHash)
+	if err != nil {
+		log.Printf("check file access failed: user=%s, filehash=%s, err=%v", username, fileHash, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check file access"})
+		return nil, false
+	}
+	if uf == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found"})
+		return nil, false
+	}
+	return uf, true
+}
+
+// ownedFile 按 file_id 或 filehash 参数查找当前用户的文件（当前版本），找不到时写出 404
+// 删除、重命名等修改操作只认归属，不认共享；同一内容复制出多份时，filehash 只能定位到最早的一份，需要用 file_id 指定
+func ownedFile(w http.ResponseWriter, r *http.Request, username string) (*db.UserFile, bool) {
+	return findUserFile(w, r, username, db.GetUserFileByID, db.GetUserFileByHash)
+}
+
+// recycledFile 按 file_id 或 filehash 参数查找当前用户回收站中的文件，找不到时写出 404
+func recycledFile(w http.ResponseWriter, r *http.Request, username string) (*db.UserFile, bool) {
+	return findUserFile(w, r, username, db.GetRecycledUserFile, db.GetRecycledUserFileByHash)
+}
+
+func findUserFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	username string,
+	byID func(context.Context, string, int64) (*db.UserFile, error),
+	byHash func(context.Context, string, string) (*db.UserFile, error),
+) (*db.UserFile, bool) {
+	var (
+		uf  *db.UserFile
+		err error
+	)
+	if idStr := r.FormValue("file_id"); idStr != "" {
+		id, perr := strconv.ParseInt(idStr, 10, 64)
+		if perr != nil || id <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid file_id"})
+			return nil, false
+		}
+		uf, err = byID(r.Context(), username, id)
+	} else if fileHash := r.FormValue("filehash"); fileHash != "" {
+		uf, err = byHash(r.Context(), username, fileHash)
+	} else {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file_id or filehash is required"})
+		return nil, false
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found"})
+		return nil, false
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get file"})
+		return nil, false
+	}
+	return uf, true
+}
@@ -0,0 +1,450 @@
+package handler
+
+/**
+ * @Description: 管理员接口，路由上用 auth.RequireRole 限定角色
+ */
+
+import (
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+)
+
+// adminTarget 读取被操作的用户名并确认用户存在（已删除的用户视为不存在）
+// allowSelf 为 false 时禁止管理员对自己操作，避免把自己禁用或降级后无人可管
+func adminTarget(w http.ResponseWriter, r *http.Request, allowSelf bool) (operator, username string, ok bool) {
+	operator, _ = auth.UsernameFromContext(r.Context())
+
+	username = r.FormValue("username")
+	if username == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username is required"})
+		return "", "", false
+	}
+	if !allowSelf && username == operator {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot apply this operation to yourself"})
+		return "", "", false
+	}
+
+	if _, err := db.GetUserRole(r.Context(), username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get user"})
+		}
+		return "", "", false
+	}
+	return operator, username, true
+}
+
+// ListUsersHandler 用户列表：GET /admin/users?q=&status=&role=&page=&page_size=
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := &db.UserQuery{
+		Keyword: query.Get("q"),
+		Role:    query.Get("role"),
+	}
+	if statusStr := query.Get("status"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid status"})
+			return
+		}
+		q.Status = &status
+	}
+
+	page, pageSize := 1, 20
+	if p, err := strconv.Atoi(query.Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	if ps, err := strconv.Atoi(query.Get("page_size")); err == nil && ps > 0 && ps <= 100 {
+		pageSize = ps
+	}
+	q.Offset, q.Limit = (page-1)*pageSize, pageSize
+
+	users, total, err := db.ListUsers(r.Context(), q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list users"})
+		return
+	}
+
+	items := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		items = append(items, map[string]interface{}{
+			"username":        u.UserName,
+			"email":           u.Email,
+			"email_validated": u.EmailValidated,
+			"signup_at":       u.SignupAt,
+			"last_active":     u.LastActive,
+			"status":          u.Status,
+			"role":            u.Role,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"users":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// DisableUserHandler 禁用用户并下线其所有会话：POST /admin/user/disable
+func DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	if _, err := db.SetUserStatus(r.Context(), username, db.UserStatusDisabled); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to disable user"})
+		return
+	}
+	if _, err := redis.DeleteOtherSessions(r.Context(), username, ""); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+
+	LogOperation(r.Context(), r, operator, mq.OpUserDisable, mq.ResourceTypeUser, username, nil)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// EnableUserHandler 重新启用被禁用或锁定的用户：POST /admin/user/enable
+func EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	if _, err := db.SetUserStatus(r.Context(), username, db.UserStatusEnabled); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to enable user"})
+		return
+	}
+	// 顺带清掉锁定和失败计数
+	if _, err := unlockAccount(r.Context(), username); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to enable user"})
+		return
+	}
+
+	LogOperation(r.Context(), r, operator, mq.OpUserEnable, mq.ResourceTypeUser, username, nil)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// DeleteUserHandler 标记删除用户，下线会话并吊销所有 API Key：POST /admin/user/delete
+// 用户的文件保留，便于审计和恢复
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := db.SetUserStatus(ctx, username, db.UserStatusDeleted); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+	if _, err := db.RevokeAllAPIKeys(ctx, username); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke api keys"})
+		return
+	}
+	if _, err := redis.DeleteOtherSessions(ctx, username, ""); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+	_ = redis.DeleteUserInfoCache(ctx, username)
+
+	LogOperation(ctx, r, operator, mq.OpUserDelete, mq.ResourceTypeUser, username, nil)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// SetUserRoleHandler 修改用户角色：POST /admin/user/role
+func SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	role := r.FormValue("role")
+	switch role {
+	case db.RoleUser, db.RoleAdmin, db.RoleAuditor:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role"})
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	if _, err := db.SetUserRole(r.Context(), username, role); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set role"})
+		return
+	}
+
+	LogOperation(r.Context(), r, operator, mq.OpUserRoleChange, mq.ResourceTypeUser, username, map[string]string{
+		"role": role,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// PlansHandler 套餐列表 / 新增或修改套餐：GET、POST /admin/plans
+// POST 参数：name、quota（字节，0 为不限）
+func PlansHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		plans, err := db.ListPlans(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list plans"})
+			return
+		}
+		if plans == nil {
+			plans = []*db.Plan{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"plans": plans})
+	case http.MethodPost:
+		operator, _ := auth.UsernameFromContext(r.Context())
+		name := r.FormValue("name")
+		if name == "" || len(name) > 32 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name must be between 1 and 32 characters"})
+			return
+		}
+		quota, err := strconv.ParseInt(r.FormValue("quota"), 10, 64)
+		if err != nil || quota < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quota"})
+			return
+		}
+
+		if err := db.UpsertPlan(r.Context(), name, quota); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save plan"})
+			return
+		}
+
+		LogOperation(r.Context(), r, operator, mq.OpPlanUpdate, mq.ResourceTypePlan, name, map[string]string{
+			"quota": strconv.FormatInt(quota, 10),
+		})
+
+		writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// SetUserPlanHandler 修改用户套餐：POST /admin/user/plan
+// 参数：username、plan、quota（可选，单独为该用户设置配额，不填则使用套餐配额）
+func SetUserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	plan := r.FormValue("plan")
+	exists, err := db.PlanExists(ctx, plan)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get plan"})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid plan"})
+		return
+	}
+
+	var quota *int64
+	extra := map[string]string{"plan": plan}
+	if raw := r.FormValue("quota"); raw != "" {
+		q, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || q < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quota"})
+			return
+		}
+		quota = &q
+		extra["quota"] = raw
+	}
+
+	if err := db.SetUserPlan(ctx, username, plan, quota); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set plan"})
+		return
+	}
+
+	LogOperation(ctx, r, operator, mq.OpUserPlanChange, mq.ResourceTypeUser, username, extra)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// UserFilesHandler 查看指定用户的文件：GET /admin/user/files?username=
+// 其余查询参数与 /file/list 相同
+func UserFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 查看他人数据同样记录在操作者名下
+	operator, username, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+	LogOperation(r.Context(), r, operator, mq.OpAdminViewFiles, mq.ResourceTypeUser, username, map[string]string{
+		"query": r.URL.RawQuery,
+	})
+
+	listUserFiles(w, r, username)
+}
+
+// UserLogsAdminHandler 查看指定用户的操作日志：GET /admin/user/logs?username=
+func UserLogsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+	LogOperation(r.Context(), r, operator, mq.OpAdminViewLogs, mq.ResourceTypeUser, username, nil)
+
+	writeUserLogs(w, r, username)
+}
+
+// UserSessionsHandler 查看指定用户的在线会话：GET /admin/user/sessions?username=
+func UserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, username, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+	LogOperation(r.Context(), r, operator, mq.OpAdminViewSessions, mq.ResourceTypeUser, username, nil)
+
+	sessions, err := redis.ListSessions(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+		return
+	}
+
+	items := sessionItems(sessions, "")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": items,
+		"count":    len(items),
+	})
+}
+
+// ForceLogoutHandler 强制下线：POST /admin/user/logout
+// 带 session_id 时只下线该会话，否则下线该用户的全部会话
+func ForceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, _ := auth.UsernameFromContext(r.Context())
+	username := r.FormValue("username")
+	if username == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username is required"})
+		return
+	}
+
+	ctx := r.Context()
+	if sid := r.FormValue("session_id"); sid != "" {
+		s, err := redis.GetSession(ctx, sid)
+		if err != nil || s.Username != username {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+			return
+		}
+		if err := redis.DeleteSession(ctx, username, sid); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+			return
+		}
+		LogOperation(ctx, r, operator, mq.OpForceLogout, mq.ResourceTypeSession, sid, map[string]string{
+			"username": username,
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": 1})
+		return
+	}
+
+	n, err := redis.DeleteOtherSessions(ctx, username, "")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+	LogOperation(ctx, r, operator, mq.OpForceLogout, mq.ResourceTypeUser, username, map[string]string{
+		"revoked": strconv.Itoa(n),
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": n})
+}
+
+// UnlockUserHandler 解除账户锁定：POST /admin/user/unlock
+func UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, _ := auth.UsernameFromContext(r.Context())
+	username := r.FormValue("username")
+	if username == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username is required"})
+		return
+	}
+
+	unlocked, err := unlockAccount(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to unlock user"})
+		return
+	}
+	if !unlocked {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "user is not locked"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		operator,
+		mq.OpAccountUnlock,
+		mq.ResourceTypeUser,
+		username,
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
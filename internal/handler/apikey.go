@@ -0,0 +1,172 @@
+package handler
+
+/**
+ * @Description: 个人 API Key 管理
+ */
+
+import (
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 每个用户最多持有的 API Key 数量
+	maxAPIKeysPerUser = 20
+	// API Key 最长有效期（天）
+	maxAPIKeyExpiresDays = 3650
+)
+
+// APIKeysHandler 列出 / 创建 API Key：GET、POST /user/apikeys
+func APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listAPIKeys(w, r)
+	case http.MethodPost:
+		createAPIKey(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := db.ListAPIKeys(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list api keys"})
+		return
+	}
+	if keys == nil {
+		keys = []*db.APIKey{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+// createAPIKey 创建 API Key，明文只在本次响应中返回
+// 参数：name、scopes（逗号分隔：read,upload,delete）、expires_in_days（可选，不填永不过期）
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 64 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name must be between 1 and 64 characters"})
+		return
+	}
+	scopes, err := auth.ParseScopes(r.FormValue("scopes"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var expiresAt *time.Time
+	if raw := r.FormValue("expires_in_days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days <= 0 || days > maxAPIKeyExpiresDays {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in_days"})
+			return
+		}
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	count, err := db.CountAPIKeys(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create api key"})
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "too many api keys"})
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create api key"})
+		return
+	}
+	k := &db.APIKey{
+		UserName:  username,
+		Name:      name,
+		KeyPrefix: prefix,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.CreateAPIKey(r.Context(), k, hash); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create api key"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpAPIKeyCreate,
+		mq.ResourceTypeAPIKey,
+		strconv.FormatInt(k.ID, 10),
+		map[string]string{"name": name, "scopes": scopes},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"api_key": k,
+		"key":     key,
+	})
+}
+
+// RevokeAPIKeyHandler 吊销 API Key：DELETE /user/apikeys/{id}
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid api key id"})
+		return
+	}
+
+	revoked, err := db.RevokeAPIKey(r.Context(), username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke api key"})
+		return
+	}
+	if !revoked {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "api key not found"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpAPIKeyRevoke,
+		mq.ResourceTypeAPIKey,
+		strconv.FormatInt(id, 10),
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
@@ -0,0 +1,106 @@
+package auth
+
+/**
+ * @Description: 个人 API Key 认证
+ * 脚本和 CI 通过 X-API-Key 请求头携带 API Key，不占用登录会话；
+ * 每个 Key 只能访问声明了所需权限范围（scope）的接口，账户管理类接口只接受登录会话
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"log"
+	"strings"
+)
+
+// API Key 权限范围
+const (
+	ScopeRead   = "read"   // 列表、下载、查看元信息
+	ScopeUpload = "upload" // 上传及创建、重命名、移动、恢复
+	ScopeDelete = "delete" // 删除
+)
+
+// APIKeyHeader 携带 API Key 的请求头
+const APIKeyHeader = "X-API-Key"
+
+const (
+	apiKeyPrefix    = "fsk_"
+	apiKeyPrefixLen = 12 // 展示用前缀长度（含 fsk_）
+)
+
+var allScopes = []string{ScopeRead, ScopeUpload, ScopeDelete}
+
+// GenerateAPIKey 生成新的 API Key，返回明文、展示用前缀和存储用哈希
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, key[:apiKeyPrefixLen], hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes 解析逗号分隔的权限范围，去重并按固定顺序返回
+func ParseScopes(raw string) (string, error) {
+	requested := make(map[string]bool)
+	for _, s := range strings.Split(raw, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if s != ScopeRead && s != ScopeUpload && s != ScopeDelete {
+			return "", errors.New("unknown scope: " + s)
+		}
+		requested[s] = true
+	}
+	if len(requested) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+
+	var scopes []string
+	for _, s := range allScopes {
+		if requested[s] {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, ","), nil
+}
+
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey 校验 API Key 及其权限范围，返回 Key 信息
+func authenticateAPIKey(ctx context.Context, key, scope string) (*db.APIKey, bool, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, false, nil
+	}
+	k, err := db.GetActiveAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		return nil, false, err
+	}
+
+	// 最后使用时间异步更新，不阻塞请求
+	go func(id int64) {
+		if err := db.TouchAPIKey(context.Background(), id); err != nil {
+			log.Printf("更新 API Key 使用时间失败 (id: %d): %v", id, err)
+		}
+	}(k.ID)
+
+	return k, hasScope(k.Scopes, scope), nil
+}
@@ -0,0 +1,407 @@
+package auth
+
+/**
+ * @Description: JWT 签名密钥管理
+ * 密钥从 JWT_PRIVATE_KEY 环境变量（PEM 内容）或 JWT_KEY_DIR 目录下的 *.pem 文件加载，
+ * 每个密钥以 RFC 7638 thumbprint 作为 kid 写入 JWT 头部。
+ * 目录中的私钥按文件修改时间先后生效；被新密钥取代的旧密钥在 access token 有效期内继续用于验签，之后自动下线。
+ * 开启定时轮换后，活跃密钥超过轮换间隔会生成新密钥写入目录（多实例部署时目录需共享）。
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+)
+
+const (
+	// 轮换生成的密钥文件前缀，只有这类文件会在下线后被删除
+	rotatedKeyPrefix = "jwt-"
+	rsaKeyBits       = 2048
+	// 检查是否需要轮换 / 重新加载密钥目录的间隔
+	keyCheckInterval = time.Minute
+	// 新密钥发布到 JWKS 后延迟多久开始签名（与 JWKS 的缓存时间一致）
+	keyPublishDelay = 5 * time.Minute
+)
+
+// signingKey 一个 RS256 密钥，Private 为空时只用于验签
+type signingKey struct {
+	ID        string
+	Private   *rsa.PrivateKey
+	Public    *rsa.PublicKey
+	CreatedAt time.Time
+	path      string
+}
+
+// keySet 当前可用的密钥集合
+type keySet struct {
+	active *signingKey // 用于签名
+	newest *signingKey // 最新生成的密钥（可能尚未开始签名）
+	byID   map[string]*signingKey
+	all    []*signingKey
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *keySet
+)
+
+// InitKeys 加载 JWT 签名密钥，服务启动时调用
+func InitKeys() error {
+	if err := reloadKeys(); err != nil {
+		return err
+	}
+	ks := currentKeys()
+	log.Printf("JWT 密钥加载完成 (活跃 kid: %s, 共 %d 个)", ks.active.ID, len(ks.all))
+	return nil
+}
+
+func reloadKeys() error {
+	ks, err := loadKeys()
+	if err != nil {
+		return err
+	}
+	keysMu.Lock()
+	keys = ks
+	keysMu.Unlock()
+	return nil
+}
+
+func currentKeys() *keySet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return keys
+}
+
+// loadKeys 从环境变量和密钥目录加载密钥
+func loadKeys() (*keySet, error) {
+	var pinned *signingKey
+	if pemData := config.JWTPrivateKey; pemData != "" {
+		k, err := parseKeyPEM([]byte(pemData))
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT_PRIVATE_KEY: %w", err)
+		}
+		if k.Private == nil {
+			return nil, errors.New("JWT_PRIVATE_KEY is not a private key")
+		}
+		pinned = k
+	}
+
+	fromDir, err := loadKeyDir(config.JWTKeyDir)
+	if err != nil {
+		return nil, err
+	}
+	return buildKeySet(pinned, fromDir, time.Now())
+}
+
+// loadKeyDir 读取目录下全部 *.pem 文件，目录不存在时返回空
+func loadKeyDir(dir string) ([]*signingKey, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*signingKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		k, err := parseKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		k.CreatedAt = info.ModTime()
+		k.path = path
+		result = append(result, k)
+	}
+	return result, nil
+}
+
+// buildKeySet 选出签名密钥并剔除已过验签宽限期的旧密钥
+// 私钥按创建时间排序：新密钥先发布到 JWKS，经过 keyPublishDelay 后才开始签名，
+// 避免其他服务缓存的 JWKS 中还没有新 kid；被取代的旧私钥继续验签一个 access token 有效期后下线。
+// 只有公钥的文件（且没有对应私钥）视为外部托管的验签密钥，始终保留
+func buildKeySet(pinned *signingKey, candidates []*signingKey, now time.Time) (*keySet, error) {
+	var privates, publics []*signingKey
+	seen := make(map[string]bool)
+	if pinned != nil {
+		seen[pinned.ID] = true
+	}
+	for _, k := range candidates {
+		if k.Private != nil && !seen[k.ID] {
+			seen[k.ID] = true
+			privates = append(privates, k)
+		}
+	}
+	for _, k := range candidates {
+		if k.Private == nil && !seen[k.ID] {
+			seen[k.ID] = true
+			publics = append(publics, k)
+		}
+	}
+
+	ks := &keySet{byID: make(map[string]*signingKey)}
+
+	if pinned != nil {
+		// 环境变量指定的密钥固定用于签名，目录中的其他密钥只验签，由运维自行下线
+		ks.active = pinned
+		ks.newest = pinned
+		ks.all = append(ks.all, pinned)
+		ks.all = append(ks.all, privates...)
+	} else {
+		if len(privates) == 0 {
+			return nil, errors.New("no JWT private key configured")
+		}
+		sort.SliceStable(privates, func(i, j int) bool {
+			return privates[i].CreatedAt.Before(privates[j].CreatedAt)
+		})
+
+		// 已发布足够久的最新私钥用于签名；都太新时（例如首次部署）使用最早的私钥
+		activeIdx := 0
+		for i, k := range privates {
+			if now.Sub(k.CreatedAt) >= keyPublishDelay {
+				activeIdx = i
+			}
+		}
+		ks.active = privates[activeIdx]
+		ks.newest = privates[len(privates)-1]
+
+		grace := time.Duration(config.AccessTokenTTL) * time.Second
+		for i, k := range privates {
+			// 后继密钥开始签名后再过 grace，旧密钥签发的 token 均已过期
+			if i < activeIdx && now.Sub(privates[i+1].CreatedAt.Add(keyPublishDelay)) > grace {
+				retireKey(k)
+				continue
+			}
+			ks.all = append(ks.all, k)
+		}
+	}
+
+	ks.all = append(ks.all, publics...)
+	for _, k := range ks.all {
+		ks.byID[k.ID] = k
+	}
+	return ks, nil
+}
+
+// retireKey 删除已下线的轮换密钥文件，手工放置的密钥文件保留不动
+func retireKey(k *signingKey) {
+	if k.path == "" || !strings.HasPrefix(filepath.Base(k.path), rotatedKeyPrefix) {
+		return
+	}
+	if err := os.Remove(k.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("删除过期 JWT 密钥失败 (%s): %v", k.path, err)
+		return
+	}
+	log.Printf("JWT 密钥已下线 (kid: %s)", k.ID)
+}
+
+// parseKeyPEM 解析 PEM 编码的 RSA 私钥（PKCS8 / PKCS1）或公钥（PKIX）
+func parseKeyPEM(data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	k := &signingKey{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		k.Private = rsaKey
+		k.Public = &rsaKey.PublicKey
+	case "RSA PRIVATE KEY":
+		rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k.Private = rsaKey
+		k.Public = &rsaKey.PublicKey
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaPub, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not an RSA key")
+		}
+		k.Public = rsaPub
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	k.ID = keyThumbprint(k.Public)
+	return k, nil
+}
+
+// keyThumbprint 计算 RFC 7638 JWK thumbprint 作为 kid
+func keyThumbprint(pub *rsa.PublicKey) string {
+	// 必需成员按字典序排列、无空白
+	canonical := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, encodeExponent(pub.E), encodeModulus(pub.N))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeModulus(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func encodeExponent(e int) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(e)).Bytes())
+}
+
+// ==================== 定时轮换 ====================
+
+// StartKeyRotation 启动密钥轮换任务：定期重新加载密钥目录（获取其他实例生成的新密钥、下线过期密钥），
+// 活跃密钥超过轮换间隔时生成新密钥
+func StartKeyRotation() {
+	interval := time.Duration(config.JWTKeyRotateInterval) * time.Second
+	if interval <= 0 {
+		log.Println("JWT 密钥轮换已关闭")
+		return
+	}
+	if config.JWTPrivateKey != "" {
+		log.Println("JWT 签名密钥由 JWT_PRIVATE_KEY 指定，跳过自动轮换")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(keyCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := rotateKeys(interval); err != nil {
+				log.Printf("JWT 密钥轮换失败: %v", err)
+			}
+		}
+	}()
+	log.Printf("JWT 密钥轮换任务已启动 (间隔: %s)", interval)
+}
+
+// rotateKeys 执行一轮检查，多实例部署时通过分布式锁保证只有一个实例生成新密钥
+func rotateKeys(interval time.Duration) error {
+	if ks := currentKeys(); ks != nil && time.Since(ks.newest.CreatedAt) >= interval {
+		lock := redis.NewLock(context.Background(), "lock:jwt:rotate", keyCheckInterval)
+		locked, err := lock.TryLock()
+		if err != nil {
+			return err
+		}
+		if locked {
+			defer lock.Unlock()
+			// 拿到锁后重新加载，避免其他实例刚刚轮换过
+			if err := reloadKeys(); err != nil {
+				return err
+			}
+			if time.Since(currentKeys().newest.CreatedAt) >= interval {
+				if err := generateKeyFile(config.JWTKeyDir); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return reloadKeys()
+}
+
+// generateKeyFile 生成新的 RSA 私钥并写入密钥目录
+func generateKeyFile(dir string) error {
+	if dir == "" {
+		return errors.New("JWT_KEY_DIR is not configured")
+	}
+	privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免其他实例读到半个文件
+	name := fmt.Sprintf("%s%d.pem", rotatedKeyPrefix, time.Now().Unix())
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	log.Printf("已生成新的 JWT 签名密钥 (kid: %s)", keyThumbprint(&privateKey.PublicKey))
+	return nil
+}
+
+// ==================== JWKS ====================
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSHandler 公开当前全部验签公钥，供其他服务校验本服务签发的 token：GET /.well-known/jwks.json
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ks := currentKeys()
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: make([]jwk, 0, len(ks.all))}
+	for _, k := range ks.all {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: k.ID,
+			N:   encodeModulus(k.Public.N),
+			E:   encodeExponent(k.Public.E),
+		})
+	}
+
+	// 缓存时间不超过 keyPublishDelay，新密钥开始签名前调用方已能拿到它
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(set)
+}
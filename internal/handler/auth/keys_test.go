@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"file-storage-linhe/config"
+)
+
+var (
+	testKeysOnce sync.Once
+	testKeyFiles [][]byte
+)
+
+// testKeyPEMs 生成 3 个测试用私钥（PEM），整个包只生成一次
+func testKeyPEMs(t *testing.T) [][]byte {
+	t.Helper()
+	testKeysOnce.Do(func() {
+		dir := t.TempDir()
+		for i := 0; i < 3; i++ {
+			if err := generateKeyFile(dir); err != nil {
+				t.Fatalf("generateKeyFile: %v", err)
+			}
+			paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+			for _, p := range paths {
+				data, _ := os.ReadFile(p)
+				_ = os.Remove(p)
+				testKeyFiles = append(testKeyFiles, data)
+			}
+		}
+	})
+	if len(testKeyFiles) != 3 {
+		t.Fatal("test keys were not generated")
+	}
+	return testKeyFiles
+}
+
+// writeTestKeys 把私钥写入目录，文件修改时间分别为 now 减去 ages
+func writeTestKeys(t *testing.T, dir string, now time.Time, ages ...time.Duration) []string {
+	t.Helper()
+	pems := testKeyPEMs(t)
+	var ids []string
+	for i, age := range ages {
+		path := filepath.Join(dir, rotatedKeyPrefix+string(rune('a'+i))+".pem")
+		if err := os.WriteFile(path, pems[i], 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		k, err := parseKeyPEM(pems[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+func TestBuildKeySetRotation(t *testing.T) {
+	grace := time.Duration(config.AccessTokenTTL) * time.Second
+
+	tests := []struct {
+		name string
+		// 各私钥距 now 的时间，从旧到新
+		ages        []time.Duration
+		wantActive  int
+		wantNewest  int
+		wantRetired []int
+	}{
+		{
+			name:       "single fresh key signs immediately",
+			ages:       []time.Duration{time.Second},
+			wantActive: 0,
+			wantNewest: 0,
+		},
+		{
+			name:       "new key is published before it signs",
+			ages:       []time.Duration{24 * time.Hour, keyPublishDelay / 2},
+			wantActive: 0,
+			wantNewest: 1,
+		},
+		{
+			name:       "new key signs after publish delay, old key still verifies",
+			ages:       []time.Duration{24 * time.Hour, keyPublishDelay + time.Minute},
+			wantActive: 1,
+			wantNewest: 1,
+		},
+		{
+			name:        "old key retired after access token grace",
+			ages:        []time.Duration{48 * time.Hour, keyPublishDelay + grace + time.Minute},
+			wantActive:  1,
+			wantNewest:  1,
+			wantRetired: []int{0},
+		},
+		{
+			name:        "three generations",
+			ages:        []time.Duration{72 * time.Hour, 24 * time.Hour, keyPublishDelay / 2},
+			wantActive:  1,
+			wantNewest:  2,
+			wantRetired: []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			ids := writeTestKeys(t, dir, now, tt.ages...)
+
+			candidates, err := loadKeyDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ks, err := buildKeySet(nil, candidates, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ks.active.ID != ids[tt.wantActive] {
+				t.Errorf("active key = key %d, want key %d", indexOf(ids, ks.active.ID), tt.wantActive)
+			}
+			if ks.newest.ID != ids[tt.wantNewest] {
+				t.Errorf("newest key = key %d, want key %d", indexOf(ids, ks.newest.ID), tt.wantNewest)
+			}
+
+			retired := map[int]bool{}
+			for _, i := range tt.wantRetired {
+				retired[i] = true
+			}
+			for i, id := range ids {
+				_, published := ks.byID[id]
+				if published == retired[i] {
+					t.Errorf("key %d published = %v, want %v", i, published, !retired[i])
+				}
+			}
+
+			// 下线的轮换密钥文件被删除
+			left, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+			if len(left) != len(ids)-len(tt.wantRetired) {
+				t.Errorf("%d key files left, want %d", len(left), len(ids)-len(tt.wantRetired))
+			}
+		})
+	}
+}
+
+func indexOf(ids []string, id string) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBuildKeySetNoPrivateKey(t *testing.T) {
+	if _, err := buildKeySet(nil, nil, time.Now()); err == nil {
+		t.Error("buildKeySet without private keys should fail")
+	}
+}
+
+// setTestKeys 替换全局密钥集合，测试结束后恢复
+func setTestKeys(t *testing.T, ks *keySet) {
+	t.Helper()
+	old := currentKeys()
+	keysMu.Lock()
+	keys = ks
+	keysMu.Unlock()
+	t.Cleanup(func() {
+		keysMu.Lock()
+		keys = old
+		keysMu.Unlock()
+	})
+}
+
+// 轮换期间旧密钥签发的 token 继续有效，旧密钥下线后失效
+func TestTokenAcrossRotation(t *testing.T) {
+	grace := time.Duration(config.AccessTokenTTL) * time.Second
+	dir := t.TempDir()
+	now := time.Now()
+	writeTestKeys(t, dir, now, 24*time.Hour)
+
+	load := func(at time.Time) *keySet {
+		candidates, err := loadKeyDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, err := buildKeySet(nil, candidates, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ks
+	}
+
+	setTestKeys(t, load(now))
+	oldToken, err := GenerateToken("alice", "sid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 生成新密钥，超过发布延迟后开始签名
+	if err := generateKeyFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	rotatedAt := now.Add(keyPublishDelay + time.Minute)
+	setTestKeys(t, load(rotatedAt))
+	newToken, err := GenerateToken("alice", "sid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if claims, err := ParseToken(token); err != nil || claims.Username != "alice" {
+			t.Errorf("%s token during rotation: claims = %v, err = %v", name, claims, err)
+		}
+	}
+
+	// 新密钥签名超过 access token 有效期后，旧密钥下线
+	setTestKeys(t, load(now.Add(keyPublishDelay+grace+2*time.Minute)))
+	if _, err := ParseToken(oldToken); err == nil {
+		t.Error("token signed by retired key should be rejected")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ids := writeTestKeys(t, dir, now, 24*time.Hour, keyPublishDelay/2)
+	candidates, err := loadKeyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := buildKeySet(nil, candidates, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestKeys(t, ks)
+
+	tests := []struct {
+		method     string
+		wantStatus int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		JWKSHandler(rec, httptest.NewRequest(tt.method, "/.well-known/jwks.json", nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s status = %d, want %d", tt.method, rec.Code, tt.wantStatus)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	JWKSHandler(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	// 尚未开始签名的新密钥也要提前发布
+	got := map[string]bool{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Alg != "RS256" || k.Use != "sig" || k.N == "" || k.E == "" {
+			t.Errorf("malformed jwk: %+v", k)
+		}
+		got[k.Kid] = true
+	}
+	for i, id := range ids {
+		if !got[id] {
+			t.Errorf("key %d missing from JWKS", i)
+		}
+	}
+}
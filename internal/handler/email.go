@@ -0,0 +1,300 @@
+package handler
+
+/**
+ * @Description: 邮箱绑定与验证
+ * 验证链接携带一次性随机 token，token 和用户名、邮箱保存在 Redis 中；
+ * 重新发送或更换邮箱后旧链接立即作废
+ */
+
+import (
+	"context"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mailer"
+	"file-storage-linhe/internal/mq"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 同一用户两次发送验证邮件的最小间隔
+const emailVerifyRequestInterval = time.Minute
+
+// 资料字段长度上限
+const maxProfileLength = 4096
+
+// normalizeEmail 校验邮箱格式（不接受带显示名的地址），统一转为小写
+func normalizeEmail(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > 64 {
+		return "", false
+	}
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw {
+		return "", false
+	}
+	return strings.ToLower(raw), true
+}
+
+// sendVerificationEmail 生成验证 token 并异步发送邮箱验证链接
+func sendVerificationEmail(ctx context.Context, username, email string) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(config.EmailVerifyTTL) * time.Second
+	if err := redis.SetEmailVerifyToken(ctx, username, email, token, ttl); err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(config.PublicBaseURL, "/") + "/user/email/verify?token=" + url.QueryEscape(token)
+	mailer.SendAsync(mailer.Message{
+		To:      email,
+		Subject: "验证邮箱",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 小时内打开以下链接完成邮箱验证：\n%s\n\n如果不是你本人操作，请忽略这封邮件。\n",
+			username, int(ttl.Hours()), link),
+	})
+	return nil
+}
+
+// RequireVerifiedEmail 要求当前用户已验证邮箱，需放在 auth.Auth 之后
+func RequireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := auth.UsernameFromContext(r.Context())
+		if !ok || username == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		u, err := db.GetUserInfo(r.Context(), username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "get user info failed"})
+			return
+		}
+		if u.Email == "" || !u.EmailValidated {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "email not verified"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// VerifyEmailHandler 邮件中的验证链接：GET /user/email/verify
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid verification link"})
+		return
+	}
+	username, email, err := redis.ConsumeEmailVerifyToken(r.Context(), token)
+	if errors.Is(err, redis.ErrEmailVerifyTokenInvalid) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired verification link"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify email"})
+		return
+	}
+
+	updated, err := db.SetEmailValidated(r.Context(), username, email)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify email"})
+		return
+	}
+	if !updated {
+		// 邮箱已变更（旧 token 未及时作废）或已验证
+		u, err := db.GetUserInfo(r.Context(), username)
+		if err != nil || u.Email != email {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid verification link"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+		return
+	}
+	_ = redis.DeleteUserInfoCache(r.Context(), username)
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpEmailVerify,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{"email": email},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// ResendVerificationHandler 重新发送验证邮件：POST /user/email/verify/resend
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	u, err := db.GetUserInfo(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "get user info failed"})
+		return
+	}
+	if u.Email == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no email bound"})
+		return
+	}
+	if u.EmailValidated {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "email already verified"})
+		return
+	}
+
+	allowed, err := redis.AllowEmailVerifyRequest(r.Context(), username, emailVerifyRequestInterval)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to send verification email"})
+		return
+	}
+	if !allowed {
+		writeRetryAfter(w, http.StatusTooManyRequests, emailVerifyRequestInterval, "verification email sent recently, retry later")
+		return
+	}
+
+	if err := sendVerificationEmail(r.Context(), username, u.Email); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to send verification email"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// UpdateProfileHandler 修改个人资料：POST /user/profile
+// 只更新请求中出现的字段；更换或解绑邮箱需要提供 password（当前密码），更换后需要重新验证
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid form"})
+		return
+	}
+
+	u, err := db.GetUserInfo(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "get user info failed"})
+		return
+	}
+
+	email, profile := u.Email, u.Profile
+	if _, ok := r.Form["email"]; ok {
+		email = ""
+		if raw := r.FormValue("email"); raw != "" {
+			normalized, valid := normalizeEmail(raw)
+			if !valid {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
+				return
+			}
+			email = normalized
+		}
+	}
+	if _, ok := r.Form["profile"]; ok {
+		profile = r.FormValue("profile")
+		if len(profile) > maxProfileLength {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "profile too long"})
+			return
+		}
+	}
+
+	emailChanged := email != u.Email
+	if emailChanged {
+		// 邮箱用于找回密码，仅凭 token 不能修改
+		withPwd, err := db.GetUserByNameWithPwd(r.Context(), username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update profile"})
+			return
+		}
+		password := r.FormValue("password")
+		if password == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "password is required to change email"})
+			return
+		}
+		if !verifyPassword(withPwd.UserPwd, password) {
+			LogOperationError(
+				r.Context(),
+				r,
+				username,
+				mq.OpProfileUpdate,
+				mq.ResourceTypeUser,
+				username,
+				"修改邮箱密码错误",
+			)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid password"})
+			return
+		}
+	}
+	if emailChanged && email != "" {
+		inUse, err := db.EmailInUse(r.Context(), email, username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update profile"})
+			return
+		}
+		if inUse {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "email already in use"})
+			return
+		}
+	}
+
+	if err := db.UpdateUserProfile(r.Context(), username, email, profile); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update profile"})
+		return
+	}
+	_ = redis.DeleteUserInfoCache(r.Context(), username)
+
+	if emailChanged {
+		if email != "" {
+			if err := sendVerificationEmail(r.Context(), username, email); err != nil {
+				log.Printf("send verification email failed: user=%s, err=%v", username, err)
+			}
+		} else {
+			_ = redis.DeleteEmailVerifyToken(r.Context(), username)
+		}
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpProfileUpdate,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{"email_changed": strconv.FormatBool(emailChanged)},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":               "OK",
+		"verification_pending": emailChanged && email != "",
+	})
+}
@@ -5,7 +5,14 @@ package handler
  */
 
 import (
-	"file-storage-linhe/config"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	cacheRedis "file-storage-linhe/internal/cache/redis"
 	"file-storage-linhe/internal/db"
 	"file-storage-linhe/internal/handler/auth"
@@ -16,18 +23,21 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/big"
+	"mime/multipart"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
 )
 
 // ======================= 上传 & 下载 =======================
 
 // 上传文件：POST /file/upload
+// 带 owner 时上传到 owner 以读写权限共享给自己的目录。owner 已有相同内容的文件时另建一条记录，不影响原文件；
+// 秒传、分片上传和预签名直传不支持 owner
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -41,37 +51,65 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	// 流式读取 multipart，不再整体落盘到 /tmp
+	part, fields, err := nextFilePart(r)
+	if errors.Is(err, errFormFieldTooLarge) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "form field too large"})
 		return
 	}
-	file, header, err := r.FormFile("file")
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	fileMeta := &meta.FileMeta{
-		FileName:   header.Filename,
-		Location:   "/tmp/" + header.Filename,
-		UploadTime: time.Now(),
+	// 目标目录：parent_id 需放在 file 字段之前，也可以通过 query 传递
+	parentIDStr, owner := fields["parent_id"], fields["owner"]
+	if parentIDStr == "" {
+		parentIDStr = r.URL.Query().Get("parent_id")
+	}
+	if owner == "" {
+		owner = r.URL.Query().Get("owner")
 	}
-	newFile, err := os.Create(fileMeta.Location)
+	owner, parentID, err := resolveUploadFolder(r.Context(), username, owner, parentIDStr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeUploadFolderError(w, err)
 		return
 	}
-	defer newFile.Close()
 
-	fileMeta.FileSize, err = io.Copy(newFile, file)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// 请求体大小已知时在读取文件内容之前检查配额（请求体包含表单开销，略大于文件本身）
+	// 分块传输时大小未知，读完后再按实际大小检查
+	if err := checkQuota(r.Context(), owner, max(r.ContentLength, 0)); err != nil {
+		writeQuotaError(w, err)
 		return
 	}
 
-	newFile.Seek(0, 0)
-	fileMeta.FileSha1 = util.FileSha1(newFile)
+	fileMeta := &meta.FileMeta{
+		FileName:   part.FileName(),
+		UploadTime: time.Now(),
+	}
+
+	// 边写临时对象边计算 SHA1
+	tmpKey := "tmp/" + uuid.NewString()
+	defer func() {
+		// 无论成功失败都清理临时对象（请求 ctx 可能已取消，这里用 Background）
+		_ = store.Backend.RemoveObject(context.Background(), tmpKey)
+	}()
+
+	hashReader := util.NewSha1Reader(part)
+	if _, err := store.Backend.PutObject(r.Context(), tmpKey, hashReader, -1, "application/octet-stream"); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "upload to storage failed"})
+		return
+	}
+	fileMeta.FileSha1 = hashReader.Sum()
+	fileMeta.FileSize = hashReader.Size()
+
+	if r.ContentLength < 0 {
+		if err := checkQuota(r.Context(), owner, fileMeta.FileSize); err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+	}
 
 	//基于文件哈希的分布式锁（避免重复上传同一底层对象）
 	lockKey := "lock:" + fileMeta.FileSha1
@@ -88,27 +126,19 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer lock.Unlock()
 
-	// 游标重新回到文件头部，准备上传到 MinIO
-	newFile.Seek(0, 0)
-
-	// 上传到 MinIO
+	// 临时对象提升为 files/<sha1>；已存在则说明内容相同，直接复用
 	objectKey := "files/" + fileMeta.FileSha1
-	info, err := store.MinioClient.PutObject(
-		r.Context(),
-		config.MinioBucket,
-		objectKey,
-		newFile,
-		fileMeta.FileSize,
-		minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
-		},
-	)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "upload to minio failed"})
+	if _, err := store.Backend.StatObject(r.Context(), objectKey); err == store.ErrObjectNotFound {
+		if _, err := store.Promote(r.Context(), tmpKey, objectKey); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "upload to storage failed"})
+			return
+		}
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to stat object"})
 		return
 	}
 
-	// 更新 Location 为 MinIO 的对象路径
+	// Location 为对象存储中的 key
 	fileMeta.Location = objectKey
 
 	// 写入数据库
@@ -120,6 +150,22 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	// 写入缓存
 	_ = cacheRedis.SetFileMetaCache(r.Context(), fileMeta)
 
+	// 写入用户-文件关系表
+	inserted, err := db.InsertUserFile(r.Context(), &db.UserFile{
+		UserName: owner,
+		FileSha1: fileMeta.FileSha1,
+		FileName: fileMeta.FileName,
+		FileSize: fileMeta.FileSize,
+		ParentID: parentID,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to insert user file relation"})
+		return
+	}
+	if inserted {
+		addStorageUsage(r.Context(), owner, fileMeta.FileSize)
+	}
+
 	// 记录上传成功日志
 	LogOperation(
 		r.Context(),
@@ -131,6 +177,7 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		map[string]string{
 			"file_name": fileMeta.FileName,
 			"file_size": strconv.FormatInt(fileMeta.FileSize, 10),
+			"owner":     owner,
 		},
 	)
 
@@ -139,14 +186,53 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"file_sha1": fileMeta.FileSha1,
 		"file_name": fileMeta.FileName,
-		"file_size": info.Size,
+		"file_size": fileMeta.FileSize,
+		"parent_id": parentID,
+		"owner":     owner,
 		"location":  objectKey,
 	})
 }
 
+// 流式上传中普通字段的长度上限
+const maxFormFieldSize = 1024
+
+var errFormFieldTooLarge = errors.New("form field too large")
+
+// nextFilePart 在 multipart 请求体中定位到 file 字段，返回的 part 可直接流式读取
+// file 之前的普通字段一并返回（流式读取无法回头，普通字段需放在 file 之前）；字段超过 1KB 返回 errFormFieldTooLarge
+func nextFilePart(r *http.Request) (*multipart.Part, map[string]string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, fields, nil
+		}
+		if part.FileName() == "" {
+			// 多读一个字节判断是否超长，超长时拒绝而不是截断
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(value) > maxFormFieldSize {
+				return nil, nil, errFormFieldTooLarge
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
+}
+
 // 下载文件：GET /file/download
+// 支持 Range / If-Range（单段和多段）以及 If-None-Match / If-Modified-Since 条件请求
 func DownloadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
@@ -165,6 +251,12 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 校验文件归属
+	uf, ok := authorizeFile(w, r, username, fileHash)
+	if !ok {
+		return
+	}
+
 	// 从数据库看文件元信息
 	fm, err := db.GetFileMeta(r.Context(), fileHash)
 	if err != nil || fm == nil {
@@ -172,41 +264,22 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 从 MinIO 获取对象
-	obg, err := store.MinioClient.GetObject(
-		r.Context(),
-		config.MinioBucket,
-		fm.Location,
-		minio.GetObjectOptions{},
-	)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// 记录下载日志（断点续传的后续分段请求不重复记录）
+	if r.Method == http.MethodGet && isFirstRangeRequest(r) {
+		LogOperation(
+			r.Context(),
+			r,
+			username,
+			mq.OpDownload,
+			mq.ResourceTypeFile,
+			fileHash,
+			map[string]string{
+				"file_name": uf.FileName,
+			},
+		)
 	}
-	defer obg.Close()
 
-	// 记录下载日志
-	LogOperation(
-		r.Context(),
-		r,
-		username,
-		mq.OpDownload,
-		mq.ResourceTypeFile,
-		fileHash,
-		map[string]string{
-			"file_name": fm.FileName,
-		},
-	)
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fm.FileName+"\"")
-
-	// 把 MinIO 数据流拷贝到 HTTP 响应
-	if _, err := io.Copy(w, obg); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	serveFileContent(w, r, fm, uf.FileName, "attachment", "application/octet-stream")
 }
 
 // 获取文件元信息：GET /file/meta
@@ -216,6 +289,12 @@ func FileMetaHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	// 获取文件哈希
 	fileHash := r.URL.Query().Get("filehash")
 	if fileHash == "" {
@@ -223,6 +302,12 @@ func FileMetaHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 校验文件归属
+	uf, ok := authorizeFile(w, r, username, fileHash)
+	if !ok {
+		return
+	}
+
 	// 从缓存获取文件元信息（缓存未命中时自动查DB并回写）
 	fm, err := cacheRedis.GetFileMetaCache(r.Context(), fileHash, db.GetFileMeta)
 	if err != nil || fm == nil {
@@ -233,20 +318,52 @@ func FileMetaHandler(w http.ResponseWriter, r *http.Request) {
 	// 返回文件元信息
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"file_sha1":   fm.FileSha1,
-		"file_name":   fm.FileName,
+		"file_name":   uf.FileName,
 		"file_size":   fm.FileSize,
 		"location":    fm.Location,
 		"upload_time": fm.UploadTime,
 	})
 }
 
-// 快速上传：POST /file/fastupload
+// ======================= 秒传 =======================
+
+const (
+	// 秒传挑战有效期
+	fastUploadChallengeTTL = 5 * time.Minute
+	// 挑战区间的最大长度
+	fastUploadChallengeMaxLen int64 = 64 * 1024
+)
+
+// fastUploadChallengeKey 挑战 key 带上用户名，其他用户拿到 challenge_id 也无法使用或作废
+func fastUploadChallengeKey(username, challengeID string) string {
+	return "fastupload:challenge:" + username + ":" + challengeID
+}
+
+// fastUploadProof 计算秒传证明：SHA1(nonce || 区间内容)，十六进制小写
+// nonce 每次挑战随机生成，小文件的区间覆盖整个文件时证明也不等于文件 hash
+func fastUploadProof(nonce string, content io.Reader) (string, error) {
+	h := sha1.New()
+	h.Write([]byte(nonce))
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 秒传第一步（申请挑战）：POST /file/fastupload
+// 服务端已有该文件时，随机选取一段字节区间和一个 nonce 作为挑战，客户端需证明确实持有文件内容
 func FastUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	_ = r.ParseForm()
 	fileHash := r.FormValue("filehash")
 	if fileHash == "" {
@@ -257,19 +374,337 @@ func FastUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fm, err := db.GetFileMeta(r.Context(), fileHash)
+	ctx := r.Context()
+	if rejectUploadOwner(w, r, username) {
+		return
+	}
+	parentID, err := resolveFolderID(ctx, username, r.FormValue("parent_id"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	// 服务端没有该文件，客户端需走普通上传
+	fm, err := db.GetFileMeta(ctx, fileHash)
 	if err != nil || fm == nil || fm.FileSha1 == "" {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found, please upload it"})
+		return
+	}
+
+	if err := checkQuota(ctx, username, fm.FileSize); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
+	fileName := r.FormValue("filename")
+	if fileName == "" {
+		fileName = fm.FileName
+	}
+
+	// 随机选取挑战区间
+	length := fm.FileSize
+	if length > fastUploadChallengeMaxLen {
+		length = fastUploadChallengeMaxLen
+	}
+	offset, err := randomInt63n(fm.FileSize - length + 1)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create challenge"})
+		return
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create challenge"})
+		return
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	challengeID := uuid.NewString()
+	challengeKey := fastUploadChallengeKey(username, challengeID)
+	pipe := cacheRedis.Rdb.TxPipeline()
+	pipe.HSet(ctx, challengeKey, map[string]interface{}{
+		"file_sha1": fm.FileSha1,
+		"file_name": fileName,
+		"file_size": fm.FileSize,
+		"parent_id": parentID,
+		"offset":    offset,
+		"length":    length,
+		"nonce":     nonce,
+	})
+	pipe.Expire(ctx, challengeKey, fastUploadChallengeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save challenge"})
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"result":      "fast upload success",
-		"file_sha1":   fm.FileSha1,
-		"file_name":   fm.FileName,
-		"file_size":   fm.FileSize,
-		"location":    fm.Location,
-		"upload_time": fm.UploadTime,
+		"challenge_id": challengeID,
+		"nonce":        nonce,
+		"offset":       offset,
+		"length":       length,
+		"expires_in":   int(fastUploadChallengeTTL.Seconds()),
+	})
+}
+
+// 秒传第二步（提交证明）：POST /file/fastupload/verify
+// proof 为 SHA1(nonce || 文件 [offset, offset+length) 区间内容)，nonce 按返回的字符串原样拼接；
+// 校验通过后才建立用户-文件关系
+func FastUploadVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	challengeID := r.FormValue("challenge_id")
+	proof := strings.ToLower(r.FormValue("proof"))
+	if challengeID == "" || proof == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	challengeKey := fastUploadChallengeKey(username, challengeID)
+
+	// 挑战只能使用一次：取出和删除在同一个事务中完成，并发提交时只有一个请求能拿到挑战
+	pipe := cacheRedis.Rdb.TxPipeline()
+	getCmd := pipe.HGetAll(ctx, challengeKey)
+	pipe.Del(ctx, challengeKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get challenge"})
+		return
+	}
+	info := getCmd.Val()
+	if len(info) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "challenge not found or expired"})
+		return
+	}
+
+	fileSha1 := info["file_sha1"]
+	fileName := info["file_name"]
+	fileSize, _ := strconv.ParseInt(info["file_size"], 10, 64)
+	parentID, _ := strconv.ParseInt(info["parent_id"], 10, 64)
+	offset, _ := strconv.ParseInt(info["offset"], 10, 64)
+	length, _ := strconv.ParseInt(info["length"], 10, 64)
+
+	fm, err := db.GetFileMeta(ctx, fileSha1)
+	if err != nil || fm == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found, please upload it"})
+		return
+	}
+
+	// 读取挑战区间并计算证明
+	rc, err := store.Backend.GetObjectRange(ctx, fm.Location, offset, length)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read object"})
+		return
+	}
+	defer rc.Close()
+	expected, err := fastUploadProof(info["nonce"], rc)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read object"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(proof)) != 1 {
+		LogOperationError(ctx, r, username, mq.OpFastUpload, mq.ResourceTypeFile, fileSha1, "秒传校验失败")
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "proof mismatch"})
+		return
+	}
+
+	// 校验通过，把文件加入用户网盘（挑战期间用量可能已变化，再检查一次配额）
+	if err := checkQuota(ctx, username, fileSize); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+	inserted, err := db.InsertUserFile(ctx, &db.UserFile{
+		UserName: username,
+		FileSha1: fileSha1,
+		FileName: fileName,
+		FileSize: fileSize,
+		ParentID: parentID,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to insert user file relation"})
+		return
+	}
+	if inserted {
+		addStorageUsage(ctx, username, fileSize)
+	}
+
+	LogOperation(ctx, r, username, mq.OpFastUpload, mq.ResourceTypeFile, fileSha1,
+		map[string]string{
+			"file_name": fileName,
+			"file_size": strconv.FormatInt(fileSize, 10),
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":    "fast upload success",
+		"file_sha1": fileSha1,
+		"file_name": fileName,
+		"file_size": fileSize,
+		"parent_id": parentID,
+	})
+}
+
+// randomInt63n 返回 [0, n) 内的安全随机数
+func randomInt63n(n int64) (int64, error) {
+	if n <= 1 {
+		return 0, nil
+	}
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64(), nil
+}
+
+// ======================= 列表 & 搜索 =======================
+
+// 文件列表游标（base64 编码后返回给前端，前端原样带回）
+type fileListCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeFileListCursor(c *fileListCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFileListCursor(s string) (*fileListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &fileListCursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 文件列表 & 搜索：GET /file/list
+// 参数：limit、cursor、sort(name/size/upload_time)、order(asc/desc)、
+// ext(逗号分隔扩展名)、mime(image/png 或 image/*)、q(文件名关键字)、folder_id 或 path(不传则查全部目录)
+func ListFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	listUserFiles(w, r, username)
+}
+
+// listUserFiles 按查询参数列出指定用户的文件（管理员查看他人文件时复用）
+func listUserFiles(w http.ResponseWriter, r *http.Request, username string) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	q := &db.UserFileQuery{
+		UserName: username,
+		Keyword:  query.Get("q"),
+		SortBy:   query.Get("sort"),
+		Limit:    50, // 默认50条
+	}
+
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= 200 {
+		q.Limit = l
+	}
+
+	switch q.SortBy {
+	case "":
+		q.SortBy = "upload_time"
+	case "name", "size", "upload_time":
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sort field"})
+		return
+	}
+
+	// 上传时间默认倒序，其余默认正序
+	switch query.Get("order") {
+	case "":
+		q.Desc = q.SortBy == "upload_time"
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid order"})
+		return
+	}
+
+	if exts := query.Get("ext"); exts != "" {
+		for _, ext := range strings.Split(exts, ",") {
+			ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+			if ext != "" {
+				q.Exts = append(q.Exts, ext)
+			}
+		}
+	}
+
+	// image/* 等价于前缀 image/
+	q.MimeType = strings.TrimSuffix(strings.ToLower(query.Get("mime")), "*")
+
+	if query.Get("folder_id") != "" || query.Get("path") != "" {
+		parentID, err := resolveFolder(ctx, username, query.Get("folder_id"), query.Get("path"))
+		if err != nil {
+			writeFolderError(w, err)
+			return
+		}
+		q.ParentID = &parentID
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeFileListCursor(cursorStr)
+		if err != nil || cursor.Sort != q.SortBy {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		q.AfterVal, q.AfterID, q.HasCursor = cursor.Value, cursor.ID, true
+	}
+
+	// 多查一条用于判断是否还有下一页
+	limit := q.Limit
+	q.Limit = limit + 1
+	files, err := db.QueryUserFiles(ctx, q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list files"})
+		return
+	}
+
+	hasMore := len(files) > limit
+	nextCursor := ""
+	if hasMore {
+		files = files[:limit]
+		last := files[len(files)-1]
+		nextCursor = encodeFileListCursor(&fileListCursor{
+			Sort:  q.SortBy,
+			Value: db.UserFileSortValue(last, q.SortBy),
+			ID:    last.ID,
+		})
+	}
+	if files == nil {
+		files = []*db.UserFile{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"files":       files,
+		"count":       len(files),
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -289,13 +724,14 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileHash := r.URL.Query().Get("filehash")
-	if fileHash == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	ctx := r.Context()
+
+	// 只能删除自己持有的文件：file_id 指定其中一份，filehash 为兼容旧客户端
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
 		return
 	}
-
-	ctx := r.Context()
+	fileHash := uf.FileSha1
 
 	// 源文件信息，拿到 MinIO 的 key
 	fm, err := db.GetFileMeta(ctx, fileHash)
@@ -304,9 +740,9 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 删除当前用户这条用户文件记录（username + filehash）
+	// 删除当前用户这条用户文件记录，同一内容的其他副本不受影响
 	// 软删
-	if err := db.DeleteUserFile(ctx, username, fileHash); err != nil {
+	if err := db.DeleteUserFile(ctx, username, uf.ID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user file record"})
 		return
 	}
@@ -327,10 +763,11 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 发送 MQ 延迟删除消息（3天后自动删除）
 	msg := &mq.FileDeleteMessage{
-		Username:  username,
-		FileHash:  fileHash,
-		FileName:  fm.FileName,
-		DeletedAt: time.Now(),
+		Username:   username,
+		UserFileID: uf.ID,
+		FileHash:   fileHash,
+		FileName:   uf.FileName,
+		DeletedAt:  time.Now(),
 	}
 	if err := mq.PublishFileDeleteMessage(ctx, msg); err != nil {
 		log.Printf("Failed to publish delete message: %v", err)
@@ -345,7 +782,8 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		mq.ResourceTypeFile,
 		fileHash,
 		map[string]string{
-			"file_name": fm.FileName,
+			"file_id":   strconv.FormatInt(uf.ID, 10),
+			"file_name": uf.FileName,
 		},
 	)
 
@@ -394,14 +832,22 @@ func RestoreFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_ = r.ParseForm()
-	fileHash := r.FormValue("filehash")
-	if fileHash == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	policy := r.FormValue("on_conflict")
+	if !validConflictPolicy(policy) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid on_conflict"})
+		return
+	}
+
+	// 只能恢复自己回收站中的文件
+	uf, ok := recycledFile(w, r, username)
+	if !ok {
 		return
 	}
 
-	if err := db.RestoreUserFile(r.Context(), username, fileHash); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to restore file"})
+	// 原目录下已有同名文件时按 on_conflict 拒绝或自动改名
+	restored, err := db.RestoreUserFile(r.Context(), username, uf.ID, policy == conflictRename)
+	if err != nil {
+		writeFileNameError(w, err)
 		return
 	}
 
@@ -411,12 +857,19 @@ func RestoreFileHandler(w http.ResponseWriter, r *http.Request) {
 		username,
 		mq.OpRestore,
 		mq.ResourceTypeFile,
-		fileHash,
-		nil,
+		restored.FileSha1,
+		map[string]string{
+			"file_id":   strconv.FormatInt(restored.ID, 10),
+			"file_name": restored.FileName,
+			"parent_id": strconv.FormatInt(restored.ParentID, 10),
+		},
 	)
 
-	writeJSON(w, http.StatusOK, map[string]string{
-		"result": "restore success",
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":    "restore success",
+		"file_id":   restored.ID,
+		"file_name": restored.FileName,
+		"parent_id": restored.ParentID,
 	})
 }
 
@@ -432,13 +885,13 @@ func PremanentDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	fileHash := r.URL.Query().Get("filehash")
-	if fileHash == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	ctx := r.Context()
+
+	uf, ok := recycledFile(w, r, username)
+	if !ok {
 		return
 	}
-
-	ctx := r.Context()
+	fileHash := uf.FileSha1
 
 	fm, err := db.GetFileMeta(ctx, fileHash)
 	if err != nil || fm == nil || fm.FileSha1 == "" {
@@ -446,10 +899,22 @@ func PremanentDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := db.PermanentDeleteUserFile(ctx, username, fileHash); err != nil {
+	// 历史版本随文件一起彻底删除
+	versions, err := db.PurgeFileVersions(ctx, username, uf.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete file versions"})
+		return
+	}
+	releaseFileVersions(ctx, username, versions)
+
+	purged, err := db.PermanentDeleteUserFile(ctx, username, uf.ID)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete file"})
 		return
 	}
+	if purged {
+		addStorageUsage(ctx, username, -uf.FileSize)
+	}
 
 	stillUsed, err := db.ExistsUserFileByHash(ctx, fileHash)
 	if err != nil {
@@ -477,6 +942,9 @@ func PremanentDeleteHandler(w http.ResponseWriter, r *http.Request) {
 // 默认分片大小：5MB
 const defaultChunkSize int64 = 5 * 1024 * 1024
 
+// 分片上传任务有效期
+const multipartTTL = 24 * time.Hour
+
 // 初始化分片上传：POST /file/multipart/init
 func MultipartInitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -517,6 +985,21 @@ func MultipartInitHandler(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户名
 	username, _ := auth.UsernameFromContext(r.Context())
 
+	// 合并后文件所在目录
+	if rejectUploadOwner(w, r, username) {
+		return
+	}
+	parentID, err := resolveFolderID(ctx, username, r.FormValue("parent_id"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	if err := checkQuota(ctx, username, fileSize); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
 	// 在 Redis 中写入上传任务元信息
 	_, err = cacheRedis.Rdb.HSet(ctx, infoKey, map[string]interface{}{
 		"file_sha1":   fileHash,
@@ -527,6 +1010,7 @@ func MultipartInitHandler(w http.ResponseWriter, r *http.Request) {
 		"upload_id":   uploadID,
 		"status":      "init",
 		"username":    username,
+		"parent_id":   parentID,
 		"created_at":  time.Now().Format(time.RFC3339),
 	}).Result()
 	if err != nil {
@@ -534,10 +1018,12 @@ func MultipartInitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 设置过期时间
-	ttl := time.Hour * 24
-	cacheRedis.Rdb.Expire(ctx, infoKey, ttl)
-	cacheRedis.Rdb.Expire(ctx, chunksKey, ttl)
+	// 设置过期时间，并登记到任务集合中，过期未完成的任务由后台清理分片
+	cacheRedis.Rdb.Expire(ctx, infoKey, multipartTTL)
+	cacheRedis.Rdb.Expire(ctx, chunksKey, multipartTTL)
+	if err := cacheRedis.TrackMultipartSession(ctx, uploadID, time.Now().Add(multipartTTL)); err != nil {
+		log.Printf("track multipart session failed: upload_id=%s, err=%v", uploadID, err)
+	}
 
 	// 返回前端：uploadID + 分片信息
 	writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -587,11 +1073,13 @@ func MultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	infoKey := "multipart:info:" + uploadID
 	chunksKey := "multipart:chunks:" + uploadID
+	checksumsKey := "multipart:checksums:" + uploadID
 
-	// 1. 从 redis 读取上传任务元信息，校验任务存在
-	info, err := cacheRedis.Rdb.HGetAll(ctx, infoKey).Result()
+	// 1. 从 redis 读取上传任务元信息，校验任务存在且属于当前用户
+	currentUser, _ := auth.UsernameFromContext(ctx)
+	info, err := getMultipartSession(ctx, uploadID, currentUser)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get upload info"})
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
 		return
 	}
 
@@ -617,27 +1105,38 @@ func MultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 	// 分片对象 key 格式：multipart/<uploadID>/<chunkIndex>
 	objectKey := fmt.Sprintf("multipart/%s/%d", uploadID, chunkIndex)
 
-	_, err = store.MinioClient.PutObject(
+	// 边上传边计算分片 SHA1
+	chunkReader := util.NewSha1Reader(chunkFile)
+	_, err = store.Backend.PutObject(
 		ctx,
-		config.MinioBucket,
 		objectKey,
-		chunkFile,
+		chunkReader,
 		-1, // -1标识自动读取流大小
-		minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
-		},
+		"application/octet-stream",
 	)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to upload chunk"})
 		return
 	}
 
+	// 客户端提供了分片校验值时，不一致直接拒绝并删除分片
+	chunkSha1 := chunkReader.Sum()
+	if expected := strings.ToLower(r.FormValue("chunk_sha1")); expected != "" && expected != chunkSha1 {
+		_ = store.Backend.RemoveObject(ctx, objectKey)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk checksum mismatch"})
+		return
+	}
+
 	// 5. 更新 Redis 进度：
+	// - 记录分片 SHA1
 	// - 把 chunk_index 加入 set（表示已上传）
 	// - 把 uploaded_chunks 计数 +1
 	// - 如果是首次上传分片，把 status 改为 "uploading"
 	pipe := cacheRedis.Rdb.TxPipeline()
+	pipe.HSet(ctx, checksumsKey, chunkIndex, chunkSha1)
+	pipe.Expire(ctx, checksumsKey, multipartTTL)
 	pipe.SAdd(ctx, chunksKey, chunkIndex)
+	pipe.Expire(ctx, chunksKey, multipartTTL)
 	pipe.HIncrBy(ctx, infoKey, "uploaded_chunks", 1)
 	pipe.HSet(ctx, infoKey, "status", "uploading")
 	_, err = pipe.Exec(ctx)
@@ -651,6 +1150,7 @@ func MultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 		"result":      "chunk upload success",
 		"upload_id":   uploadID,
 		"chunk_index": chunkIndex,
+		"chunk_sha1":  chunkSha1,
 	})
 }
 
@@ -668,12 +1168,13 @@ func MultipartStatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	infoKey := "multipart:info:" + uploadID
 	chunksKey := "multipart:chunks:" + uploadID
 
-	info, err := cacheRedis.Rdb.HGetAll(ctx, infoKey).Result()
+	// 只能查询自己的上传任务
+	currentUser, _ := auth.UsernameFromContext(ctx)
+	info, err := getMultipartSession(ctx, uploadID, currentUser)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get upload info"})
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
 		return
 	}
 
@@ -729,11 +1230,13 @@ func MultipartCompleteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	infoKey := "multipart:info:" + uploadID
 	chunksKey := "multipart:chunks:" + uploadID
+	checksumsKey := "multipart:checksums:" + uploadID
 
-	// 从 Redis 获取上传任务元信息
-	info, err := cacheRedis.Rdb.HGetAll(ctx, infoKey).Result()
-	if err != nil || len(info) == 0 {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "upload task not found"})
+	// 从 Redis 获取上传任务元信息（只能合并自己的上传任务）
+	currentUser, _ := auth.UsernameFromContext(ctx)
+	info, err := getMultipartSession(ctx, uploadID, currentUser)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
 		return
 	}
 
@@ -743,6 +1246,7 @@ func MultipartCompleteHandler(w http.ResponseWriter, r *http.Request) {
 	fileSize, _ := strconv.ParseInt(info["file_size"], 10, 64)
 	chunkCount, _ := strconv.Atoi(info["chunk_count"])
 	username := info["username"]
+	parentID, _ := strconv.ParseInt(info["parent_id"], 10, 64)
 
 	// 校验所有分片是否已上传完成
 	uploadedChunks, err := cacheRedis.Rdb.SCard(ctx, chunksKey).Result()
@@ -766,31 +1270,69 @@ func MultipartCompleteHandler(w http.ResponseWriter, r *http.Request) {
 	currentStatus := info["status"]
 	if currentStatus == "completed" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "upload already completed"})
+		return
 	}
 
-	// Minio ComposeObject 合并分片
-	var srcs []minio.CopySrcOptions
+	// 每个分片都必须有服务端计算的校验值
+	checksumCount, err := cacheRedis.Rdb.HLen(ctx, checksumsKey).Result()
+	if err != nil || int(checksumCount) != chunkCount {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk checksums missing, please re-upload chunks"})
+		return
+	}
+
+	// 按顺序收集分片对象
+	var srcs []string
 	for i := 0; i < chunkCount; i++ {
-		chunkKey := fmt.Sprintf("multipart/%s/%d", uploadID, i)
-		src := minio.CopySrcOptions{
-			Bucket: config.MinioBucket,
-			Object: chunkKey,
-		}
-		srcs = append(srcs, src)
+		srcs = append(srcs, fmt.Sprintf("multipart/%s/%d", uploadID, i))
 	}
 
-	finalObjectKey := "files/" + fileSha1
+	// 先合并到临时对象，校验通过后再提升为 files/<sha1>，避免错误内容覆盖共享的去重对象
+	composedKey := fmt.Sprintf("multipart/%s/composed", uploadID)
+	defer func() {
+		_ = store.Backend.RemoveObject(context.Background(), composedKey)
+	}()
 
 	// ComposeObject 合并分片
-	_, err = store.MinioClient.ComposeObject(ctx, minio.CopyDestOptions{
-		Bucket: config.MinioBucket,
-		Object: finalObjectKey,
-	}, srcs...)
+	_, err = store.Backend.ComposeObject(ctx, composedKey, srcs)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to merge chunks"})
 		return
 	}
 
+	// 服务端重新计算整个文件的 SHA1，不信任客户端声明的 file_sha1
+	actualSha1, actualSize, err := objectSha1(ctx, composedKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify merged file"})
+		return
+	}
+	if actualSha1 != fileSha1 || actualSize != fileSize {
+		// 校验失败：拒绝本次上传并清理分片和任务
+		abortMultipartUpload(uploadID)
+		LogOperationError(ctx, r, username, mq.OpUpload, mq.ResourceTypeFile, fileSha1,
+			fmt.Sprintf("分片合并校验失败: actual_sha1=%s, actual_size=%d", actualSha1, actualSize))
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file hash or size mismatch, upload aborted"})
+		return
+	}
+
+	// 与普通上传一致：基于文件哈希加锁后提升为正式对象
+	fileLock := cacheRedis.NewLock(ctx, "lock:"+fileSha1, time.Minute)
+	if locked, err := fileLock.TryLock(); err != nil || !locked {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "file is being uploaded"})
+		return
+	}
+	defer fileLock.Unlock()
+
+	finalObjectKey := "files/" + fileSha1
+	if _, err := store.Backend.StatObject(ctx, finalObjectKey); errors.Is(err, store.ErrObjectNotFound) {
+		if _, err := store.Promote(ctx, composedKey, finalObjectKey); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save merged file"})
+			return
+		}
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to stat object"})
+		return
+	}
+
 	// 写入 MySQL 文件元信息
 	fm := &meta.FileMeta{
 		FileName:   fileName,
@@ -809,27 +1351,40 @@ func MultipartCompleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 写入用户-文件关系表
 	if username != "" {
-		if err := db.InsertUserFile(ctx, username, fileSha1, fileName, fileSize); err != nil {
+		inserted, err := db.InsertUserFile(ctx, &db.UserFile{
+			UserName: username,
+			FileSha1: fileSha1,
+			FileName: fileName,
+			FileSize: fileSize,
+			ParentID: parentID,
+		})
+		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to insert user file relation"})
 			return
 		}
+		if inserted {
+			addStorageUsage(ctx, username, fileSize)
+		}
 	}
 
 	// 更新 redis 任务状态为 completed
 	cacheRedis.Rdb.HSet(ctx, infoKey, "status", "completed")
 	cacheRedis.Rdb.HSet(ctx, infoKey, "location", finalObjectKey)
 
-	// 异步删除临时分片对象
+	// 任务已完成，不再需要后台清理
+	_ = cacheRedis.UntrackMultipartSession(ctx, uploadID)
+
+	// 异步删除临时分片对象（请求结束后 ctx 会被取消，这里用 Background）
 	go func() {
-		for i := 0; i < chunkCount; i++ {
-			chunkKey := fmt.Sprintf("multipart/%s/%d", uploadID, i)
-			store.MinioClient.RemoveObject(ctx, config.MinioBucket, chunkKey, minio.RemoveObjectOptions{})
+		if _, _, err := store.RemovePrefix(context.Background(), "multipart/"+uploadID+"/"); err != nil {
+			log.Printf("remove multipart chunks failed: upload_id=%s, err=%v", uploadID, err)
 		}
 	}()
 
 	// 设置 redis key 短期 ttl（1小时）
 	cacheRedis.Rdb.Expire(ctx, infoKey, time.Hour)
 	cacheRedis.Rdb.Expire(ctx, chunksKey, time.Hour)
+	cacheRedis.Rdb.Expire(ctx, checksumsKey, time.Hour)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"result":      "multipart upload completed",
@@ -840,3 +1395,56 @@ func MultipartCompleteHandler(w http.ResponseWriter, r *http.Request) {
 		"upload_time": fm.UploadTime.Format(time.RFC3339),
 	})
 }
+
+// abortMultipartUpload 终止分片上传：删除所有分片对象和 Redis 中的任务信息
+// 返回回收的字节数
+func abortMultipartUpload(uploadID string) (int64, error) {
+	ctx := context.Background()
+	_, size, err := store.RemovePrefix(ctx, "multipart/"+uploadID+"/")
+	if err != nil {
+		return size, err
+	}
+	return size, cacheRedis.DeleteMultipartSession(ctx, uploadID)
+}
+
+// 取消分片上传：DELETE /file/multipart/abort
+func MultipartAbortHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uploadID := r.URL.Query().Get("upload_id")
+	if uploadID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	info, err := getMultipartSession(r.Context(), uploadID, username)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
+		return
+	}
+	if info["status"] == "completed" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "upload already completed"})
+		return
+	}
+
+	reclaimed, err := abortMultipartUpload(uploadID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to abort upload"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":          "upload aborted",
+		"upload_id":       uploadID,
+		"reclaimed_bytes": reclaimed,
+	})
+}
@@ -0,0 +1,221 @@
+package handler
+
+/**
+ * @Description: 文件重命名、移动、复制
+ * 文件通过 file_id（或 filehash）指定；复制只新增一条引用相同内容的记录，不占用新的存储对象。
+ * 目标目录已有同名文件时按 on_conflict 处理：reject（默认）返回 409，rename 自动改名为 "name (1).ext"
+ */
+
+import (
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+)
+
+const (
+	conflictReject = "reject"
+	conflictRename = "rename"
+)
+
+// validConflictPolicy 校验 on_conflict 参数，空值为 reject
+func validConflictPolicy(policy string) bool {
+	return policy == "" || policy == conflictReject || policy == conflictRename
+}
+
+// writeFileNameError 处理重命名、移动、复制、恢复时的错误
+func writeFileNameError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrFileNameConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "file already exists"})
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found"})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update file"})
+	}
+}
+
+// 重命名文件：POST /file/rename
+// 参数：file_id 或 filehash、name、on_conflict（reject / rename）
+func RenameFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	name, policy := r.FormValue("name"), r.FormValue("on_conflict")
+	if !validFolderName(name) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid file name"})
+		return
+	}
+	if !validConflictPolicy(policy) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid on_conflict"})
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	name, err := db.MoveUserFile(ctx, username, uf.ID, uf.ParentID, name, policy == conflictRename)
+	if err != nil {
+		writeFileNameError(w, err)
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpFileRename, mq.ResourceTypeFile, uf.FileSha1,
+		map[string]string{
+			"file_id":  strconv.FormatInt(uf.ID, 10),
+			"old_name": uf.FileName,
+			"new_name": name,
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id":   uf.ID,
+		"file_name": name,
+		"parent_id": uf.ParentID,
+	})
+}
+
+// 移动文件：POST /file/move
+// 参数：file_id 或 filehash、parent_id 或 parent_path（目标目录，为空是根目录）、on_conflict
+func MoveFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	policy := r.FormValue("on_conflict")
+	if !validConflictPolicy(policy) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid on_conflict"})
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	targetID, err := resolveFolder(ctx, username, r.FormValue("parent_id"), r.FormValue("parent_path"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	// 改名和移动在同一条语句中完成
+	name, err := db.MoveUserFile(ctx, username, uf.ID, targetID, uf.FileName, policy == conflictRename)
+	if err != nil {
+		writeFileNameError(w, err)
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpFileMove, mq.ResourceTypeFile, uf.FileSha1,
+		map[string]string{
+			"file_id":        strconv.FormatInt(uf.ID, 10),
+			"from_parent_id": strconv.FormatInt(uf.ParentID, 10),
+			"to_parent_id":   strconv.FormatInt(targetID, 10),
+			"old_name":       uf.FileName,
+			"new_name":       name,
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id":   uf.ID,
+		"file_name": name,
+		"parent_id": targetID,
+	})
+}
+
+// 复制文件：POST /file/copy
+// 参数：file_id 或 filehash、parent_id 或 parent_path（为空时复制到原目录）、name（可选，默认原名）、on_conflict
+// 副本引用相同的存储对象，但和上传的文件一样计入配额
+func CopyFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	policy := r.FormValue("on_conflict")
+	if !validConflictPolicy(policy) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid on_conflict"})
+		return
+	}
+	name := r.FormValue("name")
+	if name != "" && !validFolderName(name) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid file name"})
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+	if name == "" {
+		name = uf.FileName
+	}
+
+	ctx := r.Context()
+	targetID := uf.ParentID
+	if r.FormValue("parent_id") != "" || r.FormValue("parent_path") != "" {
+		id, err := resolveFolder(ctx, username, r.FormValue("parent_id"), r.FormValue("parent_path"))
+		if err != nil {
+			writeFolderError(w, err)
+			return
+		}
+		targetID = id
+	}
+
+	if err := checkQuota(ctx, username, uf.FileSize); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
+	copied, err := db.CopyUserFile(ctx, uf, targetID, name, policy == conflictRename)
+	if err != nil {
+		writeFileNameError(w, err)
+		return
+	}
+	addStorageUsage(ctx, username, copied.FileSize)
+
+	LogOperation(ctx, r, username, mq.OpFileCopy, mq.ResourceTypeFile, uf.FileSha1,
+		map[string]string{
+			"source_file_id": strconv.FormatInt(uf.ID, 10),
+			"file_id":        strconv.FormatInt(copied.ID, 10),
+			"file_name":      copied.FileName,
+			"parent_id":      strconv.FormatInt(targetID, 10),
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id":   copied.ID,
+		"file_name": copied.FileName,
+		"file_sha1": copied.FileSha1,
+		"file_size": copied.FileSize,
+		"parent_id": targetID,
+	})
+}
@@ -0,0 +1,469 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/store"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFastUploadProof(t *testing.T) {
+	content := []byte("small file that fits in a single challenge range")
+
+	proof, err := fastUploadProof("nonce-a", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof != sha1Hex(append([]byte("nonce-a"), content...)) {
+		t.Errorf("proof = %s, want SHA1(nonce || content)", proof)
+	}
+	// 区间覆盖整个文件时，文件 hash 不能作为证明
+	if proof == sha1Hex(content) {
+		t.Error("proof equals the file hash")
+	}
+	other, _ := fastUploadProof("nonce-b", bytes.NewReader(content))
+	if other == proof {
+		t.Error("different nonces produce the same proof")
+	}
+}
+
+// fastUploadChallenge 通过 /file/fastupload 申请挑战
+func fastUploadChallenge(t *testing.T, mock sqlmock.Sqlmock, mr *miniredis.Miniredis, content []byte) map[string]interface{} {
+	t.Helper()
+	fileHash := sha1Hex(content)
+	expectFileMeta(mock, fileHash, "a.txt", int64(len(content)), "objects/a")
+	expectQuota(mock, mr, "alice")
+
+	form := url.Values{"filehash": {fileHash}}
+	req := httptest.NewRequest(http.MethodPost, "/file/fastupload", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	auth.Auth(FastUploadHandler)(rec, withLogin(t, req, "alice"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fastupload status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var challenge map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestFastUploadVerify(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	fileHash := sha1Hex(content)
+
+	rangeProof := func(c map[string]interface{}, withNonce bool) string {
+		offset, length := int(c["offset"].(float64)), int(c["length"].(float64))
+		nonce := ""
+		if withNonce {
+			nonce = c["nonce"].(string)
+		}
+		return sha1Hex(append([]byte(nonce), content[offset:offset+length]...))
+	}
+
+	tests := []struct {
+		name string
+		user string
+		// proof 根据挑战计算提交的证明
+		proof      func(c map[string]interface{}) string
+		expect     func(mock sqlmock.Sqlmock, mr *miniredis.Miniredis)
+		wantStatus int
+		// 提交后挑战是否仍可使用
+		wantChallengeLeft bool
+	}{
+		{
+			name:  "valid proof",
+			user:  "alice",
+			proof: func(c map[string]interface{}) string { return rangeProof(c, true) },
+			expect: func(mock sqlmock.Sqlmock, mr *miniredis.Miniredis) {
+				expectFileMeta(mock, fileHash, "a.txt", int64(len(content)), "objects/a")
+				expectQuota(mock, mr, "alice")
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM tbl_user WHERE user_name = ? FOR UPDATE")).
+					WithArgs("alice").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, file_sha1, file_size, upload_at FROM tbl_user_file")).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "file_sha1", "file_size", "upload_at"}))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tbl_user_file")).
+					WithArgs("alice", fileHash, "a.txt", int64(len(content)), sqlmock.AnyArg(), int64(0)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:  "file hash is not a valid proof",
+			user:  "alice",
+			proof: func(c map[string]interface{}) string { return fileHash },
+			expect: func(mock sqlmock.Sqlmock, mr *miniredis.Miniredis) {
+				expectFileMeta(mock, fileHash, "a.txt", int64(len(content)), "objects/a")
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:  "range hash without nonce",
+			user:  "alice",
+			proof: func(c map[string]interface{}) string { return rangeProof(c, false) },
+			expect: func(mock sqlmock.Sqlmock, mr *miniredis.Miniredis) {
+				expectFileMeta(mock, fileHash, "a.txt", int64(len(content)), "objects/a")
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:              "challenge of another user",
+			user:              "bob",
+			proof:             func(c map[string]interface{}) string { return rangeProof(c, true) },
+			expect:            func(mock sqlmock.Sqlmock, mr *miniredis.Miniredis) {},
+			wantStatus:        http.StatusNotFound,
+			wantChallengeLeft: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, mr := setupTestEnv(t)
+			if _, err := store.Backend.PutObject(context.Background(), "objects/a", bytes.NewReader(content), int64(len(content)), ""); err != nil {
+				t.Fatal(err)
+			}
+			challenge := fastUploadChallenge(t, mock, mr, content)
+			// 小文件的挑战区间覆盖整个文件
+			if int(challenge["length"].(float64)) != len(content) {
+				t.Fatalf("challenge length = %v, want %d", challenge["length"], len(content))
+			}
+			tt.expect(mock, mr)
+
+			form := url.Values{
+				"challenge_id": {challenge["challenge_id"].(string)},
+				"proof":        {tt.proof(challenge)},
+			}
+			submit := func(user string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/file/fastupload/verify", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				rec := httptest.NewRecorder()
+				auth.Auth(FastUploadVerifyHandler)(rec, withLogin(t, req, user))
+				return rec
+			}
+
+			rec := submit(tt.user)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("verify status = %d, want %d, body = %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			// 挑战只能提交一次，失败后不能继续猜测
+			left := mr.Exists(fastUploadChallengeKey("alice", challenge["challenge_id"].(string)))
+			if left != tt.wantChallengeLeft {
+				t.Errorf("challenge left = %v, want %v", left, tt.wantChallengeLeft)
+			}
+			if !tt.wantChallengeLeft {
+				if rec := submit("alice"); rec.Code != http.StatusNotFound {
+					t.Errorf("second submit status = %d, want 404", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestFileListCursor(t *testing.T) {
+	tests := []*fileListCursor{
+		{Sort: "name", Value: "报告 (1).pdf", ID: 42},
+		{Sort: "size", Value: "1024", ID: 1},
+		{Sort: "upload_time", Value: "2024-01-02 03:04:05", ID: 9007199254740993},
+	}
+	for _, c := range tests {
+		encoded := encodeFileListCursor(c)
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Errorf("cursor %q is not URL safe", encoded)
+		}
+		got, err := decodeFileListCursor(encoded)
+		if err != nil {
+			t.Fatalf("decodeFileListCursor(%q): %v", encoded, err)
+		}
+		if *got != *c {
+			t.Errorf("round trip = %+v, want %+v", got, c)
+		}
+	}
+
+	for _, bad := range []string{"!!!", "bm90IGpzb24"} {
+		if _, err := decodeFileListCursor(bad); err == nil {
+			t.Errorf("decodeFileListCursor(%q) should fail", bad)
+		}
+	}
+}
+
+func TestListFilesPagination(t *testing.T) {
+	columns := []string{"id", "user_name", "file_sha1", "file_name", "file_size", "mime_type", "parent_id", "upload_at", "last_update"}
+	row := func(rows *sqlmock.Rows, id int64, name string) *sqlmock.Rows {
+		return rows.AddRow(id, "alice", "sha1-"+name, name, 10, "text/plain", 0, time.Now(), time.Now())
+	}
+	nameCursor := encodeFileListCursor(&fileListCursor{Sort: "name", Value: "b.txt", ID: 2})
+
+	tests := []struct {
+		name           string
+		query          string
+		expect         func(mock sqlmock.Sqlmock)
+		wantStatus     int
+		wantCount      int
+		wantHasMore    bool
+		wantNextCursor *fileListCursor
+	}{
+		{
+			name:  "first page has more",
+			query: "sort=name&limit=2",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("ORDER BY file_name ASC, id ASC LIMIT ?")).
+					WithArgs("alice", 3).
+					WillReturnRows(row(row(row(sqlmock.NewRows(columns), 1, "a.txt"), 2, "b.txt"), 3, "c.txt"))
+			},
+			wantStatus:     http.StatusOK,
+			wantCount:      2,
+			wantHasMore:    true,
+			wantNextCursor: &fileListCursor{Sort: "name", Value: "b.txt", ID: 2},
+		},
+		{
+			name:  "next page continues after cursor",
+			query: "sort=name&limit=2&cursor=" + nameCursor,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("(file_name > ? OR (file_name = ? AND id > ?)) ORDER BY file_name ASC, id ASC LIMIT ?")).
+					WithArgs("alice", "b.txt", "b.txt", int64(2), 3).
+					WillReturnRows(row(sqlmock.NewRows(columns), 3, "c.txt"))
+			},
+			wantStatus: http.StatusOK,
+			wantCount:  1,
+		},
+		{
+			name:  "descending order uses less-than",
+			query: "sort=name&order=desc&limit=2&cursor=" + nameCursor,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("(file_name < ? OR (file_name = ? AND id < ?)) ORDER BY file_name DESC, id DESC LIMIT ?")).
+					WithArgs("alice", "b.txt", "b.txt", int64(2), 3).
+					WillReturnRows(row(sqlmock.NewRows(columns), 1, "a.txt"))
+			},
+			wantStatus: http.StatusOK,
+			wantCount:  1,
+		},
+		{
+			name:       "cursor from another sort field",
+			query:      "sort=size&cursor=" + nameCursor,
+			expect:     func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed cursor",
+			query:      "cursor=%25%25",
+			expect:     func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, _ := setupTestEnv(t)
+			tt.expect(mock)
+
+			req := httptest.NewRequest(http.MethodGet, "/file/list?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			auth.Auth(ListFilesHandler)(rec, withLogin(t, req, "alice"))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Count      int    `json:"count"`
+				HasMore    bool   `json:"has_more"`
+				NextCursor string `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Count != tt.wantCount || resp.HasMore != tt.wantHasMore {
+				t.Errorf("count = %d, has_more = %v, want %d, %v", resp.Count, resp.HasMore, tt.wantCount, tt.wantHasMore)
+			}
+			if tt.wantNextCursor == nil {
+				if resp.NextCursor != "" {
+					t.Errorf("next_cursor = %q, want empty", resp.NextCursor)
+				}
+				return
+			}
+			got, err := decodeFileListCursor(resp.NextCursor)
+			if err != nil || *got != *tt.wantNextCursor {
+				t.Errorf("next_cursor = %+v (%v), want %+v", got, err, tt.wantNextCursor)
+			}
+		})
+	}
+}
+
+// multipartBody 构造 multipart 请求体，fields 在 file 之前
+func multipartBody(t *testing.T, fields map[string]string, fileContent string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(fileContent))
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestNextFilePart(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		wantErr error
+	}{
+		{"no fields", nil, nil},
+		{"field at limit", map[string]string{"filename": strings.Repeat("a", maxFormFieldSize)}, nil},
+		{"field over limit", map[string]string{"filename": strings.Repeat("a", maxFormFieldSize+1)}, errFormFieldTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := multipartBody(t, tt.fields, "hello")
+			req := httptest.NewRequest(http.MethodPost, "/file/upload", body)
+			req.Header.Set("Content-Type", contentType)
+
+			part, fields, err := nextFilePart(req)
+			if err != tt.wantErr {
+				t.Fatalf("nextFilePart() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer part.Close()
+			for k, v := range tt.fields {
+				if fields[k] != v {
+					t.Errorf("field %s has %d bytes, want %d", k, len(fields[k]), len(v))
+				}
+			}
+			if part.FileName() != "a.txt" {
+				t.Errorf("file part name = %q", part.FileName())
+			}
+		})
+	}
+}
+
+func TestUploadRejectsOversizedField(t *testing.T) {
+	setupTestEnv(t)
+	body, contentType := multipartBody(t, map[string]string{"filename": strings.Repeat("a", maxFormFieldSize+1)}, "hello")
+	req := httptest.NewRequest(http.MethodPost, "/file/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Length", strconv.Itoa(body.Len()))
+	rec := httptest.NewRecorder()
+	auth.Auth(UploadHandler)(rec, withLogin(t, req, "alice"))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "form field too large") {
+		t.Errorf("status = %d, body = %s, want 400 form field too large", rec.Code, rec.Body)
+	}
+}
+
+func TestMultipartSessionOwnership(t *testing.T) {
+	_, mr := setupTestEnv(t)
+	mr.HSet("multipart:info:up-1", "username", "bob", "chunk_count", "2", "status", "init")
+
+	for _, tt := range []struct {
+		user string
+		want int
+	}{
+		{"alice", http.StatusNotFound},
+		{"bob", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/file/multipart/status?upload_id=up-1", nil)
+		rec := httptest.NewRecorder()
+		auth.Auth(MultipartStatusHandler)(rec, withLogin(t, req, tt.user))
+		if rec.Code != tt.want {
+			t.Errorf("status as %s = %d, want %d", tt.user, rec.Code, tt.want)
+		}
+	}
+
+	body, contentType := multipartBody(t, map[string]string{"upload_id": "up-1", "chunk_index": "0"}, "chunk")
+	req := httptest.NewRequest(http.MethodPost, "/file/multipart/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	auth.Auth(MultipartUploadHandler)(rec, withLogin(t, req, "alice"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("upload as alice = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if mr.Exists("multipart:chunks:up-1") {
+		t.Error("chunk recorded for another user's upload")
+	}
+	if _, err := store.Backend.StatObject(context.Background(), "multipart/up-1/0"); err == nil {
+		t.Error("chunk stored for another user's upload")
+	}
+}
+
+func TestDownloadUsesUserFileName(t *testing.T) {
+	const hash = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	columns := []string{"id", "user_name", "file_sha1", "file_name", "file_size", "mime_type", "parent_id", "upload_at", "last_update"}
+	expectUserFile := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta("FROM tbl_user_file WHERE user_name = ? AND file_sha1 = ?")).
+			WithArgs("alice", hash).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, "alice", hash, "renamed.txt", 5, "text/plain", 0, time.Now(), time.Now()))
+	}
+
+	t.Run("download", func(t *testing.T) {
+		mock, _ := setupTestEnv(t)
+		if _, err := store.Backend.PutObject(context.Background(), "files/"+hash, strings.NewReader("hello"), 5, ""); err != nil {
+			t.Fatal(err)
+		}
+		expectUserFile(mock)
+		expectFileMeta(mock, hash, "original.txt", 5, "files/"+hash)
+
+		req := httptest.NewRequest(http.MethodGet, "/file/download?filehash="+hash, nil)
+		rec := httptest.NewRecorder()
+		auth.Auth(DownloadHandler)(rec, withLogin(t, req, "alice"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "renamed.txt") {
+			t.Errorf("Content-Disposition = %q, want renamed.txt", cd)
+		}
+	})
+
+	t.Run("meta", func(t *testing.T) {
+		mock, _ := setupTestEnv(t)
+		expectUserFile(mock)
+		expectFileMeta(mock, hash, "original.txt", 5, "files/"+hash)
+
+		req := httptest.NewRequest(http.MethodGet, "/file/meta?filehash="+hash, nil)
+		rec := httptest.NewRecorder()
+		auth.Auth(FileMetaHandler)(rec, withLogin(t, req, "alice"))
+		var resp struct {
+			FileName string `json:"file_name"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		if resp.FileName != "renamed.txt" {
+			t.Errorf("file_name = %q, want renamed.txt", resp.FileName)
+		}
+	})
+}
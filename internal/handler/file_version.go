@@ -0,0 +1,209 @@
+package handler
+
+/**
+ * @Description: 文件历史版本
+ * 同一目录下再次上传同名文件时自动生成新版本，可以查看、下载历史版本，
+ * 把历史版本恢复为当前版本，以及清理多余的历史版本
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+)
+
+// fileVersion 按 version_id 参数查找文件的历史版本，找不到时写出 404
+func fileVersion(w http.ResponseWriter, r *http.Request, uf *db.UserFile) (*db.FileVersion, bool) {
+	versionID, err := strconv.ParseInt(r.URL.Query().Get("version_id"), 10, 64)
+	if err != nil || versionID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid version_id"})
+		return nil, false
+	}
+
+	v, err := db.GetFileVersion(r.Context(), uf.ID, versionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "version not found"})
+		return nil, false
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get version"})
+		return nil, false
+	}
+	return v, true
+}
+
+// releaseFileVersions 历史版本被删除后释放配额，不再被引用的内容一并删除存储对象
+func releaseFileVersions(ctx context.Context, username string, versions []*db.FileVersion) {
+	for _, v := range versions {
+		addStorageUsage(ctx, username, -v.FileSize)
+		db.ReleaseFileObject(ctx, v.FileSha1)
+	}
+}
+
+// FileVersionsHandler 查看文件的版本列表：GET /file/versions?filehash=
+func FileVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+
+	versions, err := db.ListFileVersions(r.Context(), uf.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list versions"})
+		return
+	}
+	if versions == nil {
+		versions = []*db.FileVersion{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file":     uf,
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// DownloadFileVersionHandler 下载历史版本：GET /file/version/download?filehash=&version_id=
+func DownloadFileVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+	v, ok := fileVersion(w, r, uf)
+	if !ok {
+		return
+	}
+
+	fm, err := db.GetFileMeta(r.Context(), v.FileSha1)
+	if err != nil || fm == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet && isFirstRangeRequest(r) {
+		LogOperation(r.Context(), r, username, mq.OpDownload, mq.ResourceTypeFile, v.FileSha1,
+			map[string]string{
+				"file_name":  uf.FileName,
+				"version_id": strconv.FormatInt(v.ID, 10),
+			})
+	}
+
+	serveFileContent(w, r, fm, uf.FileName, "attachment", "application/octet-stream")
+}
+
+// RestoreFileVersionHandler 把历史版本恢复为当前版本：POST /file/version/restore?filehash=&version_id=
+// 恢复前的当前内容保留为一个新的历史版本
+func RestoreFileVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+	v, ok := fileVersion(w, r, uf)
+	if !ok {
+		return
+	}
+
+	// 当前版本与历史版本互换，总用量不变
+	if _, err := db.RestoreFileVersion(r.Context(), uf, v.ID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "version not found"})
+		default:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to restore version"})
+		}
+		return
+	}
+
+	LogOperation(r.Context(), r, username, mq.OpVersionRestore, mq.ResourceTypeFile, v.FileSha1,
+		map[string]string{
+			"file_name":     uf.FileName,
+			"previous_sha1": uf.FileSha1,
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id":   uf.ID,
+		"file_name": uf.FileName,
+		"file_sha1": v.FileSha1,
+		"file_size": v.FileSize,
+	})
+}
+
+// PruneFileVersionsHandler 清理历史版本，只保留最新的 keep 个：POST /file/versions/prune?filehash=&keep=
+func PruneFileVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	keep, err := strconv.Atoi(r.URL.Query().Get("keep"))
+	if err != nil || keep < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid keep"})
+		return
+	}
+
+	uf, ok := ownedFile(w, r, username)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	pruned, err := db.PruneFileVersions(ctx, uf.ID, keep)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to prune versions"})
+		return
+	}
+	releaseFileVersions(ctx, username, pruned)
+
+	LogOperation(ctx, r, username, mq.OpVersionPrune, mq.ResourceTypeFile, uf.FileSha1,
+		map[string]string{
+			"file_name": uf.FileName,
+			"keep":      strconv.Itoa(keep),
+			"pruned":    strconv.Itoa(len(pruned)),
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pruned": len(pruned)})
+}
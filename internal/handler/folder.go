@@ -0,0 +1,354 @@
+package handler
+
+/**
+ * @Description: 目录（文件夹）相关
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errFolderNotFound = errors.New("folder not found")
+
+// 校验目录名：非空、不含路径分隔符、不能是 . 或 ..
+func validFolderName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 255 {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\")
+}
+
+// resolveFolderID 解析目录ID参数并校验归属，空值或 0 表示根目录
+func resolveFolderID(ctx context.Context, username, idStr string) (int64, error) {
+	if idStr == "" {
+		return db.RootFolderID, nil
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errFolderNotFound
+	}
+	if id == db.RootFolderID {
+		return id, nil
+	}
+	if _, err := db.GetFolder(ctx, username, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errFolderNotFound
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// resolveFolderPath 按路径逐级查找目录，例如 /docs/2024
+func resolveFolderPath(ctx context.Context, username, path string) (int64, error) {
+	id := db.RootFolderID
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		f, err := db.GetChildFolder(ctx, username, id, name)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, errFolderNotFound
+			}
+			return 0, err
+		}
+		id = f.ID
+	}
+	return id, nil
+}
+
+// resolveFolder 优先按 id 参数查找目录，其次按 path 参数
+func resolveFolder(ctx context.Context, username, idStr, path string) (int64, error) {
+	if idStr == "" && path != "" {
+		return resolveFolderPath(ctx, username, path)
+	}
+	return resolveFolderID(ctx, username, idStr)
+}
+
+// folderPath 从目录向上拼出完整路径
+func folderPath(ctx context.Context, username string, id int64) (string, error) {
+	var names []string
+	for id != db.RootFolderID {
+		f, err := db.GetFolder(ctx, username, id)
+		if err != nil {
+			return "", err
+		}
+		names = append([]string{f.Name}, names...)
+		id = f.ParentID
+	}
+	return "/" + strings.Join(names, "/"), nil
+}
+
+// writeFolderError 统一处理目录查找错误
+func writeFolderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFolderNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "folder not found"})
+		return
+	}
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get folder"})
+}
+
+// writeFolderWriteError 处理创建、重命名、移动目录时的错误
+func writeFolderWriteError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, db.ErrFolderNameConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "folder already exists"})
+	case errors.Is(err, db.ErrFolderCycle):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot move folder into itself"})
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "folder not found"})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": msg})
+	}
+}
+
+// 创建目录：POST /folder/create
+func CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	name := r.FormValue("name")
+	if !validFolderName(name) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid folder name"})
+		return
+	}
+
+	ctx := r.Context()
+	parentID, err := resolveFolder(ctx, username, r.FormValue("parent_id"), r.FormValue("parent_path"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	// 同一目录下不允许重名（查重和写入在同一事务内）
+	id, err := db.CreateFolder(ctx, username, parentID, name)
+	if err != nil {
+		writeFolderWriteError(w, err, "failed to create folder")
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpFolderCreate, mq.ResourceTypeFolder, strconv.FormatInt(id, 10),
+		map[string]string{"name": name, "parent_id": strconv.FormatInt(parentID, 10)})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"folder_id": id,
+		"parent_id": parentID,
+		"name":      name,
+	})
+}
+
+// 重命名目录：POST /folder/rename
+func RenameFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	id, err := strconv.ParseInt(r.FormValue("folder_id"), 10, 64)
+	name := r.FormValue("name")
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(name) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid folder name"})
+		return
+	}
+
+	ctx := r.Context()
+	folder, err := db.RenameFolder(ctx, username, id, name)
+	if err != nil {
+		writeFolderWriteError(w, err, "failed to rename folder")
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpFolderRename, mq.ResourceTypeFolder, strconv.FormatInt(id, 10),
+		map[string]string{"old_name": folder.Name, "new_name": name})
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "rename success"})
+}
+
+// 移动目录：POST /folder/move
+func MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	id, err := strconv.ParseInt(r.FormValue("folder_id"), 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	targetID, err := resolveFolder(ctx, username, r.FormValue("parent_id"), r.FormValue("parent_path"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	// 环检测、查重和移动在同一事务内完成
+	folder, err := db.MoveFolder(ctx, username, id, targetID)
+	if err != nil {
+		writeFolderWriteError(w, err, "failed to move folder")
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpFolderMove, mq.ResourceTypeFolder, strconv.FormatInt(id, 10),
+		map[string]string{
+			"from_parent_id": strconv.FormatInt(folder.ParentID, 10),
+			"to_parent_id":   strconv.FormatInt(targetID, 10),
+		})
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "move success"})
+}
+
+// 删除目录（连同子目录和文件一起移入回收站）：DELETE /folder/delete
+func DeleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("folder_id"), 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	folder, err := db.GetFolder(ctx, username, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "folder not found"})
+		return
+	}
+
+	ids, files, err := db.DeleteFolderTree(ctx, username, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "folder not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete folder"})
+		return
+	}
+
+	// 与单文件删除一致：没有其他用户引用的文件，发送延迟删除消息
+	for _, f := range files {
+		stillUsed, err := db.ExistsUserFileByHash(ctx, f.FileSha1)
+		if err != nil || stillUsed {
+			continue
+		}
+		msg := &mq.FileDeleteMessage{
+			Username:   username,
+			UserFileID: f.ID,
+			FileHash:   f.FileSha1,
+			FileName:   f.FileName,
+			DeletedAt:  time.Now(),
+		}
+		if err := mq.PublishFileDeleteMessage(ctx, msg); err != nil {
+			log.Printf("Failed to publish delete message: %v", err)
+		}
+	}
+
+	LogOperation(ctx, r, username, mq.OpFolderDelete, mq.ResourceTypeFolder, strconv.FormatInt(id, 10),
+		map[string]string{
+			"name":          folder.Name,
+			"folder_count":  strconv.Itoa(len(ids)),
+			"deleted_files": strconv.Itoa(len(files)),
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":        "delete success",
+		"folder_count":  len(ids),
+		"deleted_files": len(files),
+	})
+}
+
+// 列出目录内容：GET /folder/list?path=/a/b 或 ?folder_id=1
+func ListFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	id, err := resolveFolder(ctx, username, query.Get("folder_id"), query.Get("path"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	path, err := folderPath(ctx, username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get folder path"})
+		return
+	}
+
+	folders, err := db.ListChildFolders(ctx, username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list folders"})
+		return
+	}
+
+	files, err := db.ListUserFilesInFolder(ctx, username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list files"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"folder_id": id,
+		"path":      path,
+		"folders":   folders,
+		"files":     files,
+	})
+}
@@ -3,9 +3,13 @@ package handler
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
 
+	"file-storage-linhe/config"
 	"file-storage-linhe/internal/mq"
 )
 
@@ -33,29 +37,69 @@ func LogOperationError(ctx context.Context, r *http.Request, username, operation
 	}
 }
 
-// getClientIP 获取客户端真实IP
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []netip.Prefix
+)
+
+// loadTrustedProxies 解析 TRUSTED_PROXIES（逗号分隔的 IP 或 CIDR），无法解析的项忽略
+func loadTrustedProxies() []netip.Prefix {
+	trustedProxiesOnce.Do(func() {
+		for _, item := range strings.Split(config.TrustedProxies, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if prefix, err := netip.ParsePrefix(item); err == nil {
+				trustedProxies = append(trustedProxies, prefix.Masked())
+			} else if addr, err := netip.ParseAddr(item); err == nil {
+				trustedProxies = append(trustedProxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			} else {
+				log.Printf("ignore invalid trusted proxy: %q", item)
+			}
+		}
+	})
+	return trustedProxies
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range loadTrustedProxies() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientIP 获取客户端真实IP（用于登录限流和操作日志）
+// 只有直连地址属于 TRUSTED_PROXIES 时才采信 X-Forwarded-For / X-Real-IP，否则客户端可以随意伪造
 func getClientIP(r *http.Request) string {
-	// 优先从 X-Real-IP 获取
-	ip := r.Header.Get("X-Real-IP")
-	if ip != "" {
-		return ip
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(remote) {
+		return host
 	}
 
-	// 其次从 X-Forwarded-For 获取
-	ip = r.Header.Get("X-Forwarded-For")
-	if ip != "" {
-		// X-Forwarded-For 可能包含多个IP，取第一个
-		ips := strings.Split(ip, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+	// X-Forwarded-For 从右往左跳过可信代理，第一个不可信的地址就是客户端
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			if !isTrustedProxy(addr) || i == 0 {
+				return addr.Unmap().String()
+			}
 		}
 	}
 
-	// 最后从 RemoteAddr 获取
-	ip = r.RemoteAddr
-	// RemoteAddr 格式为 "IP:Port"，需要去掉端口
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		return ip[:idx]
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
 	}
-	return ip
+	return host
 }
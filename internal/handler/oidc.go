@@ -0,0 +1,400 @@
+package handler
+
+/**
+ * @Description: OpenID Connect 登录
+ * /user/oidc/login 跳转到提供方授权，提供方回调 /user/oidc/callback 后换取并校验 ID Token：
+ *   1. (issuer, sub) 已关联本地用户 -> 直接登录（开启两步验证的账户还需要口令）
+ *   2. 提供方确认过的邮箱与本地已验证邮箱一致 -> 自动关联后登录
+ *   3. 开启自动创建时新建本地账户（随机密码，之后可通过找回密码设置）
+ * 已登录用户可通过 /user/oidc/link 主动关联外部账户
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"file-storage-linhe/internal/oidc"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// 跳转到提供方后等待回调的最长时间
+	oidcStateTTL = 10 * time.Minute
+	// 发起授权的浏览器持有的绑定值，回调时必须与 state 中保存的一致，
+	// 防止攻击者把自己发起的授权链接交给受害者完成（登录 CSRF / 把受害者的外部账户关联到攻击者名下）
+	oidcBindingCookie = "oidc_binding"
+)
+
+var errOIDCNoAccount = errors.New("no linked account")
+
+// startOIDCAuth 生成 state / nonce / PKCE 并返回提供方授权地址，同时在发起请求的客户端写入绑定 cookie
+// linkUser 非空表示把外部账户关联到该用户，而不是登录
+func startOIDCAuth(w http.ResponseWriter, r *http.Request, linkUser string) (string, error) {
+	p, err := oidc.GetProvider(r.Context())
+	if err != nil {
+		return "", err
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", err
+	}
+	binding, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+
+	if err := redis.SetOIDCState(r.Context(), state, map[string]string{
+		"nonce":     nonce,
+		"verifier":  verifier,
+		"link_user": linkUser,
+		"device_id": requestDeviceID(r),
+		"binding":   hashOIDCBinding(binding),
+	}, oidcStateTTL); err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     "/user/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.PublicBaseURL, "https://"),
+		// 提供方回调是顶层 GET 跳转，Lax 下会携带
+		SameSite: http.SameSiteLaxMode,
+	})
+	return p.AuthCodeURL(state, nonce, challenge), nil
+}
+
+func hashOIDCBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkOIDCBinding 校验回调请求携带的绑定 cookie 与 state 中保存的一致，并清除 cookie
+func checkOIDCBinding(w http.ResponseWriter, r *http.Request, state map[string]string) bool {
+	http.SetCookie(w, &http.Cookie{Name: oidcBindingCookie, Path: "/user/oidc/", MaxAge: -1})
+
+	c, err := r.Cookie(oidcBindingCookie)
+	if err != nil || c.Value == "" || state["binding"] == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashOIDCBinding(c.Value)), []byte(state["binding"])) == 1
+}
+
+// writeOIDCError 统一处理 OIDC 未配置或提供方异常
+func writeOIDCError(w http.ResponseWriter, err error) {
+	if errors.Is(err, oidc.ErrNotConfigured) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "oidc login is not enabled"})
+		return
+	}
+	log.Printf("oidc error: %v", err)
+	writeJSON(w, http.StatusBadGateway, map[string]string{"error": "oidc provider error"})
+}
+
+// OIDCLoginHandler 跳转到 OIDC 提供方登录：GET /user/oidc/login
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	authURL, err := startOIDCAuth(w, r, "")
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCLinkHandler 为当前用户关联外部账户：POST /user/oidc/link
+// 返回授权地址，由前端在同一浏览器中跳转（响应中的绑定 cookie 需要随回调带回）
+func OIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	authURL, err := startOIDCAuth(w, r, username)
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"auth_url": authURL})
+}
+
+// OIDCCallbackHandler 提供方授权回调：GET /user/oidc/callback
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "oidc authorization failed: " + errCode})
+		return
+	}
+	code, stateParam := q.Get("code"), q.Get("state")
+	if code == "" || stateParam == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "code or state is missing"})
+		return
+	}
+
+	state, err := redis.ConsumeOIDCState(r.Context(), stateParam)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "oidc login failed"})
+		return
+	}
+	if len(state) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired state"})
+		return
+	}
+	if !checkOIDCBinding(w, r, state) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "oidc flow was started from another browser"})
+		return
+	}
+
+	p, err := oidc.GetProvider(r.Context())
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	rawIDToken, err := p.Exchange(r.Context(), code, state["verifier"])
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	claims, err := p.VerifyIDToken(r.Context(), rawIDToken, state["nonce"])
+	if err != nil {
+		log.Printf("oidc id token rejected: %v", err)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid id token"})
+		return
+	}
+
+	if linkUser := state["link_user"]; linkUser != "" {
+		linkOIDCAccount(w, r, linkUser, claims)
+		return
+	}
+
+	username, err := resolveOIDCUser(r.Context(), claims)
+	if errors.Is(err, errOIDCNoAccount) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "no account linked to this identity"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "oidc login failed"})
+		return
+	}
+
+	// 账户状态校验与密码登录一致
+	u, err := db.GetUserByNameWithPwd(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "oidc login failed"})
+		return
+	}
+	if u.Status == db.UserStatusDisabled || u.Status == db.UserStatusDeleted {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "account disabled"})
+		return
+	}
+	lockRemaining, err := accountLockRemaining(r.Context(), u)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "oidc login failed"})
+		return
+	}
+	if lockRemaining > 0 {
+		writeRetryAfter(w, http.StatusLocked, lockRemaining, "account locked")
+		return
+	}
+
+	// 开启了两步验证的账户，外部登录同样要求口令，与密码登录走同一流程
+	mfaEnabled, err := db.IsTOTPEnabled(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "oidc login failed"})
+		return
+	}
+	if mfaEnabled {
+		startTOTPChallenge(w, r, username)
+		return
+	}
+
+	resp, err := createLoginSession(r, username, state["device_id"])
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create session"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpLogin,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{"method": "oidc", "issuer": claims.Issuer},
+	)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// linkOIDCAccount 把外部账户关联到已登录用户
+func linkOIDCAccount(w http.ResponseWriter, r *http.Request, username string, claims *oidc.Claims) {
+	linked, err := db.GetOIDCLinkedUser(r.Context(), claims.Issuer, claims.Subject)
+	if err == nil {
+		if linked != username {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "identity already linked to another account"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to link account"})
+		return
+	}
+
+	if err := db.CreateOIDCLink(r.Context(), username, claims.Issuer, claims.Subject, claims.Email); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to link account"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpOIDCLink,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{"issuer": claims.Issuer, "subject": claims.Subject},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// resolveOIDCUser 查找或创建外部账户对应的本地用户
+func resolveOIDCUser(ctx context.Context, claims *oidc.Claims) (string, error) {
+	username, err := db.GetOIDCLinkedUser(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		return username, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	// 双方都确认过的邮箱视为同一人，自动关联
+	email, emailOK := normalizeEmail(claims.Email)
+	if emailOK && claims.EmailVerified {
+		u, err := db.GetUserByEmail(ctx, email)
+		if err == nil && u.EmailValidated {
+			if err := db.CreateOIDCLink(ctx, u.UserName, claims.Issuer, claims.Subject, email); err != nil {
+				return "", err
+			}
+			return u.UserName, nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	if !config.OIDCAutoProvision {
+		return "", errOIDCNoAccount
+	}
+
+	// 邮箱已被其他账户占用（未验证）时不带入邮箱，避免冲突
+	if emailOK {
+		if inUse, err := db.EmailInUse(ctx, email, ""); err != nil {
+			return "", err
+		} else if inUse {
+			emailOK = false
+		}
+	}
+	if !emailOK {
+		email = ""
+	}
+
+	username, err = availableUsername(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+	// 随机密码：外部账户用户默认不能用密码登录，需要时可通过找回密码设置
+	randomPwd, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	hashed, err := encryptPassword(randomPwd)
+	if err != nil {
+		return "", err
+	}
+	if err := db.ProvisionOIDCUser(ctx, username, hashed, email, email != "" && claims.EmailVerified,
+		claims.Issuer, claims.Subject); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// availableUsername 根据 preferred_username / 邮箱前缀生成一个未被占用的用户名（3-20 个字符）
+func availableUsername(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := sanitizeUsername(claims.PreferredUsername)
+	if base == "" {
+		base = sanitizeUsername(strings.SplitN(claims.Email, "@", 2)[0])
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+	if len(base) > 16 {
+		base = base[:16]
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		_, err := db.GetUserByNameWithPwd(ctx, candidate)
+		if errors.Is(err, sql.ErrNoRows) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("no available username")
+}
+
+// sanitizeUsername 只保留字母、数字和 _ . -
+func sanitizeUsername(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return -1
+	}, s)
+}
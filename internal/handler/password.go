@@ -0,0 +1,284 @@
+package handler
+
+/**
+ * @Description: 修改密码与找回密码
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mailer"
+	"file-storage-linhe/internal/mq"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 同一用户两次申请重置密码的最小间隔
+const passwordResetRequestInterval = time.Minute
+
+// validatePassword 校验密码强度，不合法时返回错误信息
+func validatePassword(password string) string {
+	if len(password) < 6 {
+		return "password must be at least 6 characters"
+	}
+	return ""
+}
+
+// ChangePasswordHandler 修改密码：POST /user/password
+// 修改成功后其他设备上的会话全部下线，当前会话保留。
+// API Key 默认保留（脚本和 CI 不受影响），响应中列出仍然有效的 Key；revoke_api_keys=true 时一并吊销
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := auth.SessionIDFromContext(r.Context())
+
+	oldPassword := r.FormValue("old_password")
+	newPassword := r.FormValue("new_password")
+	if oldPassword == "" || newPassword == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "old_password or new_password is missing"})
+		return
+	}
+	if msg := validatePassword(newPassword); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	revokeKeys := false
+	if raw := r.FormValue("revoke_api_keys"); raw != "" {
+		var err error
+		if revokeKeys, err = strconv.ParseBool(raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid revoke_api_keys"})
+			return
+		}
+	}
+	if newPassword == oldPassword {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "new password must be different from the old one"})
+		return
+	}
+
+	u, err := db.GetUserByNameWithPwd(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get user"})
+		return
+	}
+	if !verifyPassword(u.UserPwd, oldPassword) {
+		LogOperationError(
+			r.Context(),
+			r,
+			username,
+			mq.OpPasswordChange,
+			mq.ResourceTypeUser,
+			username,
+			"原密码错误",
+		)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid old password"})
+		return
+	}
+
+	revoked, err := setPassword(r.Context(), username, newPassword, sessionID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to change password"})
+		return
+	}
+
+	revokedKeys := 0
+	if revokeKeys {
+		if revokedKeys, err = db.RevokeAllAPIKeys(r.Context(), username); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke api keys"})
+			return
+		}
+	}
+	keys, err := db.ListAPIKeys(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list api keys"})
+		return
+	}
+	if keys == nil {
+		keys = []*db.APIKey{}
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpPasswordChange,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{
+			"revoked_sessions": strconv.Itoa(revoked),
+			"revoked_api_keys": strconv.Itoa(revokedKeys),
+		},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":           "OK",
+		"revoked_sessions": revoked,
+		"revoked_api_keys": revokedKeys,
+		"active_api_keys":  keys,
+	})
+}
+
+// setPassword 更新密码并下线除 keepSessionID 外的全部会话
+func setPassword(ctx context.Context, username, password, keepSessionID string) (int, error) {
+	hashed, err := encryptPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.UpdateUserPassword(ctx, username, hashed); err != nil {
+		return 0, err
+	}
+	return redis.DeleteOtherSessions(ctx, username, keepSessionID)
+}
+
+// PasswordResetRequestHandler 申请重置密码：POST /user/password/reset/request
+// 按用户名或邮箱查找账户，向账户绑定的邮箱发送重置链接；
+// 无论账户是否存在都返回成功，避免被用来探测用户名
+func PasswordResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	email := strings.TrimSpace(r.FormValue("email"))
+	if username == "" && email == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username or email is required"})
+		return
+	}
+
+	var u *db.User
+	var err error
+	if username != "" {
+		u, err = db.GetUserByNameWithPwd(r.Context(), username)
+	} else {
+		u, err = db.GetUserByEmail(r.Context(), email)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to request password reset"})
+		return
+	}
+	// 未验证的邮箱不一定属于用户本人，不发送重置链接
+	if err != nil || u.Email == "" || !u.EmailValidated {
+		writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+		return
+	}
+
+	allowed, err := redis.AllowPasswordResetRequest(r.Context(), u.UserName, passwordResetRequestInterval)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to request password reset"})
+		return
+	}
+	if !allowed {
+		writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to request password reset"})
+		return
+	}
+	ttl := time.Duration(config.PasswordResetTTL) * time.Second
+	if err := redis.SetPasswordResetToken(r.Context(), u.UserName, token, ttl); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to request password reset"})
+		return
+	}
+
+	link := config.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	mailer.SendAsync(mailer.Message{
+		To:      u.Email,
+		Subject: "重置密码",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 分钟内打开以下链接重置密码：\n%s\n\n如果不是你本人操作，请忽略这封邮件。\n",
+			u.UserName, int(ttl.Minutes()), link),
+	})
+
+	LogOperation(
+		r.Context(),
+		r,
+		u.UserName,
+		mq.OpPasswordResetRequest,
+		mq.ResourceTypeUser,
+		u.UserName,
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// PasswordResetHandler 凭重置 token 设置新密码：POST /user/password/reset
+// 重置成功后该用户的全部会话下线、全部 API Key 吊销，因登录失败被锁定的账户同时解锁
+func PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.FormValue("token")
+	newPassword := r.FormValue("new_password")
+	if token == "" || newPassword == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "token or new_password is missing"})
+		return
+	}
+	// 先校验密码再消费 token，密码不合格时 token 仍可继续使用
+	if msg := validatePassword(newPassword); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	username, err := redis.ConsumePasswordResetToken(r.Context(), token)
+	if errors.Is(err, redis.ErrResetTokenInvalid) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reset password"})
+		return
+	}
+
+	revoked, err := setPassword(r.Context(), username, newPassword, "")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reset password"})
+		return
+	}
+	// 重置密码通常意味着账户可能已泄露，攻击者创建的 API Key 不能继续使用
+	revokedKeys, err := db.RevokeAllAPIKeys(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke api keys"})
+		return
+	}
+	if _, err := unlockAccount(r.Context(), username); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to unlock user"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpPasswordReset,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{
+			"revoked_sessions": strconv.Itoa(revoked),
+			"revoked_api_keys": strconv.Itoa(revokedKeys),
+		},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
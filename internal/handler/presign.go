@@ -0,0 +1,494 @@
+package handler
+
+/**
+ * @Description: 预签名直传
+ * 客户端拿到短期有效的预签名 URL 后直接读写对象存储，大文件流量不再经过 API 节点
+ * 整文件直传先写入临时对象 tmp/presign/<id>，完成回调校验 SHA1 后才提升为 files/<sha1>，
+ * 避免客户端在 URL 有效期内覆盖已去重共享的正式对象
+ * 直传使用 POST 策略，对象大小和 SHA1 由存储端校验，完成回调只需读取对象信息，不再回读内容
+ */
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"file-storage-linhe/config"
+	cacheRedis "file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/meta"
+	"file-storage-linhe/internal/mq"
+	"file-storage-linhe/internal/store"
+	"file-storage-linhe/util"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errUploadNotFound = errors.New("upload not found")
+
+func presignExpiry() time.Duration {
+	return time.Duration(config.PresignExpiry) * time.Second
+}
+
+// writePresignError 统一处理生成预签名 URL 的错误
+func writePresignError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrPresignNotSupported) {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "presigned url not supported"})
+		return
+	}
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to presign url"})
+}
+
+// objectSha1 流式读取对象并计算 SHA1 和大小
+func objectSha1(ctx context.Context, key string) (string, int64, error) {
+	rc, err := store.Backend.GetObject(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+
+	reader := util.NewSha1Reader(rc)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return "", 0, err
+	}
+	return reader.Sum(), reader.Size(), nil
+}
+
+// validSha1Hex 判断是否为 40 位 hex 的 SHA1
+func validSha1Hex(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha1.Size
+}
+
+// chunkLength 计算分片应有的大小，最后一个分片为剩余部分
+func chunkLength(info map[string]string, chunkIndex int) int64 {
+	fileSize, _ := strconv.ParseInt(info["file_size"], 10, 64)
+	chunkSize, _ := strconv.ParseInt(info["chunk_size"], 10, 64)
+	chunkCount, _ := strconv.Atoi(info["chunk_count"])
+	if chunkIndex == chunkCount-1 {
+		return fileSize - chunkSize*int64(chunkCount-1)
+	}
+	return chunkSize
+}
+
+// getMultipartSession 读取分片上传任务，并校验任务属于当前用户
+func getMultipartSession(ctx context.Context, uploadID, username string) (map[string]string, error) {
+	info, err := cacheRedis.Rdb.HGetAll(ctx, "multipart:info:"+uploadID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(info) == 0 || info["username"] != username {
+		return nil, errUploadNotFound
+	}
+	return info, nil
+}
+
+// 申请整文件直传 URL：POST /file/presign/upload
+func PresignUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	fileHash := strings.ToLower(r.FormValue("filehash"))
+	fileName := r.FormValue("filename")
+	fileSize, err := strconv.ParseInt(r.FormValue("filesize"), 10, 64)
+	// 空文件走普通上传
+	if !validSha1Hex(fileHash) || fileName == "" || err != nil || fileSize <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if rejectUploadOwner(w, r, username) {
+		return
+	}
+	parentID, err := resolveFolderID(ctx, username, r.FormValue("parent_id"))
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	if err := checkQuota(ctx, username, fileSize); err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+
+	// 服务端已有该文件时无需再传，走秒传
+	if _, err := store.Backend.StatObject(ctx, "files/"+fileHash); err == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "file already exists, use fast upload"})
+		return
+	}
+
+	uploadID := uuid.NewString()
+	objectKey := "tmp/presign/" + uploadID
+	url, fields, err := store.PresignPost(ctx, objectKey, presignExpiry(), fileSize, fileHash)
+	if err != nil {
+		writePresignError(w, err)
+		return
+	}
+
+	// 记录直传任务，完成回调时使用（多留一段时间给客户端回调）
+	infoKey := "presign:upload:" + uploadID
+	pipe := cacheRedis.Rdb.TxPipeline()
+	pipe.HSet(ctx, infoKey, map[string]interface{}{
+		"username":   username,
+		"file_sha1":  fileHash,
+		"file_name":  fileName,
+		"file_size":  fileSize,
+		"parent_id":  parentID,
+		"object_key": objectKey,
+	})
+	pipe.Expire(ctx, infoKey, presignExpiry()+time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save upload info"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"upload_id":  uploadID,
+		"method":     http.MethodPost,
+		"url":        url,
+		"fields":     fields,
+		"expires_in": config.PresignExpiry,
+	})
+}
+
+// 整文件直传完成回调：POST /file/presign/upload/complete
+// 存储端记录的大小和 SHA1 与声明一致才写入 tbl_file / tbl_user_file
+func PresignUploadCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	uploadID := r.FormValue("upload_id")
+	if uploadID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	infoKey := "presign:upload:" + uploadID
+	info, err := cacheRedis.Rdb.HGetAll(ctx, infoKey).Result()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get upload info"})
+		return
+	}
+	if len(info) == 0 || info["username"] != username {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload not found"})
+		return
+	}
+
+	fileSha1 := info["file_sha1"]
+	fileName := info["file_name"]
+	fileSize, _ := strconv.ParseInt(info["file_size"], 10, 64)
+	parentID, _ := strconv.ParseInt(info["parent_id"], 10, 64)
+	tmpKey := info["object_key"]
+
+	// 校验对象内容：POST 策略已让存储端校验过，这里只比对存储端记录的值
+	obj, err := store.Backend.StatObject(ctx, tmpKey)
+	if errors.Is(err, store.ErrObjectNotFound) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "object not uploaded"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read object"})
+		return
+	}
+
+	// 校验完成后临时对象不再需要，任务也一并作废
+	defer func() {
+		_ = store.Backend.RemoveObject(context.Background(), tmpKey)
+		cacheRedis.Rdb.Del(context.Background(), infoKey)
+	}()
+
+	if obj.Sha1 != fileSha1 || obj.Size != fileSize {
+		LogOperationError(ctx, r, username, mq.OpUpload, mq.ResourceTypeFile, fileSha1, "直传文件校验失败")
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file hash or size mismatch"})
+		return
+	}
+
+	// 与普通上传一致：基于文件哈希加锁后提升为正式对象
+	lock := cacheRedis.NewLock(ctx, "lock:"+fileSha1, time.Minute)
+	locked, err := lock.TryLock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to acquire lock"})
+		return
+	}
+	if !locked {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "file is being uploaded"})
+		return
+	}
+	defer lock.Unlock()
+
+	objectKey := "files/" + fileSha1
+	if _, err := store.Backend.StatObject(ctx, objectKey); errors.Is(err, store.ErrObjectNotFound) {
+		if _, err := store.Promote(ctx, tmpKey, objectKey); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save object"})
+			return
+		}
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to stat object"})
+		return
+	}
+
+	fm := &meta.FileMeta{
+		FileSha1:   fileSha1,
+		FileName:   fileName,
+		FileSize:   fileSize,
+		Location:   objectKey,
+		UploadTime: time.Now(),
+	}
+	if err := db.InsertFileMeta(ctx, fm); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "save file meta failed"})
+		return
+	}
+	_ = cacheRedis.SetFileMetaCache(ctx, fm)
+
+	inserted, err := db.InsertUserFile(ctx, &db.UserFile{
+		UserName: username,
+		FileSha1: fileSha1,
+		FileName: fileName,
+		FileSize: fileSize,
+		ParentID: parentID,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to insert user file relation"})
+		return
+	}
+	if inserted {
+		addStorageUsage(ctx, username, fileSize)
+	}
+
+	LogOperation(ctx, r, username, mq.OpUpload, mq.ResourceTypeFile, fileSha1,
+		map[string]string{
+			"file_name": fileName,
+			"file_size": strconv.FormatInt(fileSize, 10),
+			"direct":    "true",
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file_sha1": fileSha1,
+		"file_name": fileName,
+		"file_size": fileSize,
+		"parent_id": parentID,
+		"location":  objectKey,
+	})
+}
+
+// 申请分片直传 URL：POST /file/multipart/presign
+func PresignChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	uploadID := r.FormValue("upload_id")
+	chunkIndex, err := strconv.Atoi(r.FormValue("chunk_index"))
+	// 分片 SHA1 写入 POST 策略，由存储端校验
+	chunkSha1 := strings.ToLower(r.FormValue("chunk_sha1"))
+	if uploadID == "" || err != nil || chunkIndex < 0 || !validSha1Hex(chunkSha1) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	info, err := getMultipartSession(ctx, uploadID, username)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
+		return
+	}
+
+	chunkCount, _ := strconv.Atoi(info["chunk_count"])
+	if chunkIndex >= chunkCount {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk index out of range"})
+		return
+	}
+
+	objectKey := fmt.Sprintf("multipart/%s/%d", uploadID, chunkIndex)
+	chunkSize := chunkLength(info, chunkIndex)
+	url, fields, err := store.PresignPost(ctx, objectKey, presignExpiry(), chunkSize, chunkSha1)
+	if err != nil {
+		writePresignError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"upload_id":   uploadID,
+		"chunk_index": chunkIndex,
+		"chunk_size":  chunkSize,
+		"method":      http.MethodPost,
+		"url":         url,
+		"fields":      fields,
+		"expires_in":  config.PresignExpiry,
+	})
+}
+
+// 分片直传完成回调：POST /file/multipart/presign/complete
+// 确认分片对象已写入存储后，登记到上传进度中，之后照常调用 /file/multipart/complete 合并
+func PresignChunkCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_ = r.ParseForm()
+	uploadID := r.FormValue("upload_id")
+	chunkIndex, err := strconv.Atoi(r.FormValue("chunk_index"))
+	if uploadID == "" || err != nil || chunkIndex < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	info, err := getMultipartSession(ctx, uploadID, username)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload task not found"})
+		return
+	}
+
+	chunkCount, _ := strconv.Atoi(info["chunk_count"])
+	if chunkIndex >= chunkCount {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk index out of range"})
+		return
+	}
+
+	// 直传的分片没有经过服务端，使用存储端按 POST 策略校验并记录的 SHA1
+	objectKey := fmt.Sprintf("multipart/%s/%d", uploadID, chunkIndex)
+	obj, err := store.Backend.StatObject(ctx, objectKey)
+	if err != nil {
+		if errors.Is(err, store.ErrObjectNotFound) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk not uploaded"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to read chunk"})
+		return
+	}
+	// 没有存储端校验值说明分片不是通过预签名表单上传的（如走了服务端中转），不在这里登记
+	chunkSha1 := obj.Sha1
+	if chunkSha1 == "" || obj.Size != chunkLength(info, chunkIndex) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk not uploaded via presigned url"})
+		return
+	}
+	if expected := strings.ToLower(r.FormValue("chunk_sha1")); expected != "" && expected != chunkSha1 {
+		_ = store.Backend.RemoveObject(ctx, objectKey)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk checksum mismatch"})
+		return
+	}
+
+	// 与服务端中转上传一致，记录分片校验值并更新 Redis 进度
+	infoKey := "multipart:info:" + uploadID
+	chunksKey := "multipart:chunks:" + uploadID
+	checksumsKey := "multipart:checksums:" + uploadID
+	pipe := cacheRedis.Rdb.TxPipeline()
+	pipe.HSet(ctx, checksumsKey, chunkIndex, chunkSha1)
+	pipe.Expire(ctx, checksumsKey, multipartTTL)
+	added := pipe.SAdd(ctx, chunksKey, chunkIndex)
+	pipe.Expire(ctx, chunksKey, multipartTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update upload progress"})
+		return
+	}
+	// 同一分片重复回调时不重复计数
+	if added.Val() > 0 {
+		pipe := cacheRedis.Rdb.TxPipeline()
+		pipe.HIncrBy(ctx, infoKey, "uploaded_chunks", 1)
+		pipe.HSet(ctx, infoKey, "status", "uploading")
+		if _, err := pipe.Exec(ctx); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update upload progress"})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":      "chunk upload success",
+		"upload_id":   uploadID,
+		"chunk_index": chunkIndex,
+		"chunk_sha1":  chunkSha1,
+	})
+}
+
+// 获取预签名下载 URL：GET /file/presign/download
+func PresignDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fileHash := r.URL.Query().Get("filehash")
+	if fileHash == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uf, ok := authorizeFile(w, r, username, fileHash)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil || fm == nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get file meta"})
+		return
+	}
+
+	url, err := store.PresignGet(ctx, fm.Location, presignExpiry(), uf.FileName)
+	if err != nil {
+		writePresignError(w, err)
+		return
+	}
+
+	LogOperation(ctx, r, username, mq.OpDownload, mq.ResourceTypeFile, fileHash,
+		map[string]string{
+			"file_name": uf.FileName,
+			"direct":    "true",
+		})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"method":     http.MethodGet,
+		"url":        url,
+		"expires_in": config.PresignExpiry,
+	})
+}
@@ -0,0 +1,31 @@
+package handler
+
+import "testing"
+
+func TestChunkLength(t *testing.T) {
+	info := map[string]string{
+		"file_size":   "25",
+		"chunk_size":  "10",
+		"chunk_count": "3",
+	}
+	for index, want := range []int64{10, 10, 5} {
+		if got := chunkLength(info, index); got != want {
+			t.Errorf("chunkLength(%d) = %d, want %d", index, got, want)
+		}
+	}
+}
+
+func TestValidSha1Hex(t *testing.T) {
+	tests := map[string]bool{
+		"da39a3ee5e6b4b0d3255bfef95601890afd80709":  true,
+		"da39a3ee5e6b4b0d3255bfef95601890afd8070":   false,
+		"da39a3ee5e6b4b0d3255bfef95601890afd8070g":  false,
+		"da39a3ee5e6b4b0d3255bfef95601890afd807090": false,
+		"": false,
+	}
+	for s, want := range tests {
+		if got := validSha1Hex(s); got != want {
+			t.Errorf("validSha1Hex(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+/**
+ * @Description: 存储配额
+ * 上传类接口在接收文件内容之前检查配额，文件归属变化时同步更新用量计数
+ */
+
+import (
+	"context"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"log"
+	"net/http"
+	"time"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+func storageUsageTTL() time.Duration {
+	return time.Duration(config.StorageUsageCacheTTL) * time.Second
+}
+
+// storageUsage 返回用户已用存储、配额（0 为不限）和套餐
+func storageUsage(ctx context.Context, username string) (int64, int64, string, error) {
+	plan, quota, err := db.GetUserQuota(ctx, username)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	used, err := redis.GetStorageUsage(ctx, username, storageUsageTTL(), db.GetUserStorageUsage)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return used, quota, plan, nil
+}
+
+// checkQuota 检查用户再存入 size 字节后是否超出配额
+func checkQuota(ctx context.Context, username string, size int64) error {
+	used, quota, _, err := storageUsage(ctx, username)
+	if err != nil {
+		return err
+	}
+	if quota > 0 && used+size > quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// writeQuotaError 处理 checkQuota 的错误
+func writeQuotaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errQuotaExceeded) {
+		writeJSON(w, http.StatusInsufficientStorage, map[string]string{"error": "storage quota exceeded"})
+		return
+	}
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check storage quota"})
+}
+
+// addStorageUsage 文件新增或彻底删除后更新用量计数，失败时删除计数等待下次重新统计
+func addStorageUsage(ctx context.Context, username string, delta int64) {
+	if err := redis.IncrStorageUsage(ctx, username, delta); err != nil {
+		log.Printf("update storage usage failed: user=%s, delta=%d, err=%v", username, delta, err)
+		_ = redis.DeleteStorageUsage(ctx, username)
+	}
+}
+
+// UsageHandler 查询存储用量：GET /user/usage
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	used, quota, plan, err := storageUsage(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get storage usage"})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"plan":      plan,
+		"used":      used,
+		"quota":     quota,
+		"unlimited": quota == 0,
+	}
+	if quota > 0 {
+		remaining := quota - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		resp["remaining"] = remaining
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
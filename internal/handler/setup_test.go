@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"file-storage-linhe/config"
+	cacheRedis "file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/store"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testKeysOnce sync.Once
+
+// setupTestEnv 替换数据库（sqlmock）、Redis（miniredis）和对象存储（内存），测试结束后恢复
+func setupTestEnv(t *testing.T) (sqlmock.Sqlmock, *miniredis.Miniredis) {
+	t.Helper()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDB := db.DB
+	db.DB = mockDB
+
+	mr := miniredis.RunT(t)
+	oldRdb := cacheRedis.Rdb
+	cacheRedis.Rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	oldBackend := store.Backend
+	store.Backend = store.NewMemoryStore()
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet sql expectations: %v", err)
+		}
+		_ = mockDB.Close()
+		_ = cacheRedis.Rdb.Close()
+		db.DB, cacheRedis.Rdb, store.Backend = oldDB, oldRdb, oldBackend
+	})
+
+	testKeysOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.JWTPrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		config.JWTKeyDir = ""
+		if err := auth.InitKeys(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return mock, mr
+}
+
+// withLogin 为 username 创建会话并带上 access token，请求需交给 auth.Auth 包装的 handler
+func withLogin(t *testing.T, r *http.Request, username string) *http.Request {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	sid := "sid-" + username
+	if _, err := cacheRedis.CreateSession(ctx, &cacheRedis.Session{
+		ID: sid, Username: username, CreatedAt: now, LastSeen: now,
+	}, time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.GenerateToken(username, sid, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// expectFileMeta 预期一次 tbl_file 查询
+func expectFileMeta(mock sqlmock.Sqlmock, sha1, name string, size int64, location string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT file_sha1, file_name, file_size, file_addr FROM tbl_file WHERE file_sha1 = ?")).
+		WithArgs(sha1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_sha1", "file_name", "file_size", "file_addr"}).
+			AddRow(sha1, name, size, location))
+}
+
+// expectQuota 预期一次配额查询（不限配额），已用量从 Redis 读取
+func expectQuota(mock sqlmock.Sqlmock, mr *miniredis.Miniredis, username string) {
+	mr.Set("usage:"+username, "0")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT u.plan, COALESCE(u.quota, p.quota) FROM tbl_user u")).
+		WithArgs(username).
+		WillReturnRows(sqlmock.NewRows([]string{"plan", "quota"}).AddRow("free", 0))
+}
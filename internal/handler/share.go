@@ -0,0 +1,329 @@
+package handler
+
+/**
+ * @Description: 分享链接
+ * 登录用户为自己的文件创建链接，任何人凭链接访问 /s/{token}，
+ * 可设置访问密码、过期时间、最大访问次数和仅预览模式
+ */
+
+import (
+	"database/sql"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 分享链接最长有效期（小时）
+	maxShareExpiresHours = 24 * 365
+	// 访问密码长度限制
+	minSharePasswordLen = 4
+	maxSharePasswordLen = 64
+	// 密码错误次数上限及计数窗口，超过后该分享暂时拒绝密码校验
+	sharePasswordMaxFailures = 10
+	sharePasswordFailWindow  = 15 * time.Minute
+	// 访问密码也可以放在请求头中，避免出现在访问日志的 URL 里
+	sharePasswordHeader = "X-Share-Password"
+)
+
+// shareURL 分享链接的完整地址
+func shareURL(token string) string {
+	return strings.TrimRight(config.PublicBaseURL, "/") + "/s/" + token
+}
+
+// SharesHandler 列出 / 创建分享链接：GET、POST /share
+func SharesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listShares(w, r)
+	case http.MethodPost:
+		createShare(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listShares(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	shares, err := db.ListShares(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list shares"})
+		return
+	}
+
+	items := make([]map[string]interface{}, 0, len(shares))
+	for _, s := range shares {
+		items = append(items, map[string]interface{}{
+			"share": s,
+			"url":   shareURL(s.Token),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shares": items,
+		"count":  len(items),
+	})
+}
+
+// createShare 创建分享链接
+// 参数：filehash、password（可选）、expires_in_hours（可选，不填永不过期）、
+// max_downloads（可选，0 为不限）、preview_only（可选，true 时浏览器内打开）
+func createShare(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fileHash := r.FormValue("filehash")
+	if fileHash == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "filehash is required"})
+		return
+	}
+
+	s := &db.Share{UserName: username}
+
+	if raw := r.FormValue("expires_in_hours"); raw != "" {
+		hours, err := strconv.Atoi(raw)
+		if err != nil || hours <= 0 || hours > maxShareExpiresHours {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in_hours"})
+			return
+		}
+		t := time.Now().Add(time.Duration(hours) * time.Hour)
+		s.ExpiresAt = &t
+	}
+	if raw := r.FormValue("max_downloads"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid max_downloads"})
+			return
+		}
+		s.MaxDownloads = n
+	}
+	if raw := r.FormValue("preview_only"); raw != "" {
+		previewOnly, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid preview_only"})
+			return
+		}
+		s.PreviewOnly = previewOnly
+	}
+	if password := r.FormValue("password"); password != "" {
+		if len(password) < minSharePasswordLen || len(password) > maxSharePasswordLen {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "password must be between 4 and 64 characters"})
+			return
+		}
+		hashed, err := encryptPassword(password)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create share"})
+			return
+		}
+		s.Password = hashed
+	}
+
+	// 只能分享自己持有的文件
+	uf, err := db.GetUserFileByHash(r.Context(), username, fileHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get file"})
+		return
+	}
+	s.UserFileID, s.FileSha1, s.FileName = uf.ID, uf.FileSha1, uf.FileName
+
+	if s.Token, err = randomToken(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create share"})
+		return
+	}
+	if err := db.CreateShare(r.Context(), s); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create share"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpShareCreate,
+		mq.ResourceTypeShare,
+		strconv.FormatInt(s.ID, 10),
+		map[string]string{
+			"file_sha1":    s.FileSha1,
+			"has_password": strconv.FormatBool(s.HasPassword),
+			"preview_only": strconv.FormatBool(s.PreviewOnly),
+		},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"share": s,
+		"url":   shareURL(s.Token),
+	})
+}
+
+// RevokeShareHandler 取消分享：DELETE /share/{id}
+func RevokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid share id"})
+		return
+	}
+
+	revoked, err := db.RevokeShare(r.Context(), username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke share"})
+		return
+	}
+	if !revoked {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "share not found"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpShareRevoke,
+		mq.ResourceTypeShare,
+		strconv.FormatInt(id, 10),
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// ShareAccessHandler 公开访问分享链接：GET /s/{token}，无需登录
+// 有密码的分享通过 X-Share-Password 头或 password 参数提供密码
+// 每次访问计入访问次数并记录到分享者的操作日志；限次分享忽略 Range，不限次的分享断点续传的后续分段不计次
+func ShareAccessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	s, err := db.GetShareByToken(ctx, r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			LogOperationError(ctx, r, "", mq.OpShareAccess, mq.ResourceTypeShare, "", "share not found")
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "share not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get share"})
+		return
+	}
+	shareID := strconv.FormatInt(s.ID, 10)
+
+	if s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt) {
+		LogOperationError(ctx, r, s.UserName, mq.OpShareAccess, mq.ResourceTypeShare, shareID, "share expired")
+		writeJSON(w, http.StatusGone, map[string]string{"error": "share expired"})
+		return
+	}
+	// 限次分享不支持断点续传：忽略 Range，每个 GET 都是一次完整下载并计数。
+	// Range 由请求方决定，如果按是否从 0 开始来判断是否计次，换个起点就能无限次下载
+	limited := s.MaxDownloads > 0
+	if limited {
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+	}
+	if limited && s.DownloadCount >= s.MaxDownloads {
+		LogOperationError(ctx, r, s.UserName, mq.OpShareAccess, mq.ResourceTypeShare, shareID, "download limit reached")
+		writeJSON(w, http.StatusGone, map[string]string{"error": "download limit reached"})
+		return
+	}
+
+	if s.HasPassword && !checkSharePassword(w, r, s) {
+		return
+	}
+
+	// 不限次的分享允许断点续传，后续分段不重复计数和记录日志
+	if r.Method == http.MethodGet && (limited || isFirstRangeRequest(r)) {
+		allowed, err := db.IncrShareDownload(ctx, s.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update share"})
+			return
+		}
+		if !allowed {
+			LogOperationError(ctx, r, s.UserName, mq.OpShareAccess, mq.ResourceTypeShare, shareID, "download limit reached")
+			writeJSON(w, http.StatusGone, map[string]string{"error": "download limit reached"})
+			return
+		}
+
+		LogOperation(ctx, r, s.UserName, mq.OpShareAccess, mq.ResourceTypeShare, shareID, map[string]string{
+			"file_sha1":    s.FileSha1,
+			"file_name":    s.FileName,
+			"preview_only": strconv.FormatBool(s.PreviewOnly),
+		})
+	}
+
+	fm, err := db.GetFileMeta(ctx, s.FileSha1)
+	if err != nil || fm == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.PreviewOnly {
+		// 用户上传的内容在本站域名下内联展示，禁止嗅探并放进沙箱，防止 HTML / SVG 中的脚本执行
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		serveFileContent(w, r, fm, s.FileName, "inline", db.MimeTypeByName(s.FileName))
+		return
+	}
+	serveFileContent(w, r, fm, s.FileName, "attachment", "application/octet-stream")
+}
+
+// checkSharePassword 校验分享密码，失败时写出响应并返回 false
+func checkSharePassword(w http.ResponseWriter, r *http.Request, s *db.Share) bool {
+	ctx := r.Context()
+	shareID := strconv.FormatInt(s.ID, 10)
+
+	failures, wait, err := redis.GetSharePasswordFailure(ctx, s.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check password"})
+		return false
+	}
+	if failures >= sharePasswordMaxFailures {
+		writeRetryAfter(w, http.StatusTooManyRequests, wait, "too many password attempts, please retry later")
+		return false
+	}
+
+	password := r.Header.Get(sharePasswordHeader)
+	if password == "" {
+		password = r.URL.Query().Get("password")
+	}
+	if password == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "password required"})
+		return false
+	}
+	if !verifyPassword(s.Password, password) {
+		_, _ = redis.IncrSharePasswordFailure(ctx, s.ID, sharePasswordFailWindow)
+		LogOperationError(ctx, r, s.UserName, mq.OpShareAccess, mq.ResourceTypeShare, shareID, "invalid password")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid password"})
+		return false
+	}
+	return true
+}
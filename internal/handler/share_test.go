@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"file-storage-linhe/internal/store"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIsFirstRangeRequest(t *testing.T) {
+	tests := []struct {
+		rangeHeader string
+		want        bool
+	}{
+		{"", true},
+		{"bytes=0-", true},
+		{"bytes=0-99", true},
+		{"bytes=0-0,100-199", true},
+		{"bytes=100-", false},
+		{"bytes=-100", false},
+		{"bytes=1-", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/s/tok", nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		if got := isFirstRangeRequest(r); got != tt.want {
+			t.Errorf("isFirstRangeRequest(%q) = %v, want %v", tt.rangeHeader, got, tt.want)
+		}
+	}
+}
+
+func TestShareAccessDownloadCounting(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	shareColumns := []string{"id", "token", "user_name", "user_file_id", "file_sha1", "file_name", "password",
+		"expires_at", "max_downloads", "download_count", "preview_only", "create_at"}
+	incrSQL := regexp.QuoteMeta("UPDATE tbl_share SET download_count = download_count + 1")
+
+	tests := []struct {
+		name          string
+		method        string
+		rangeHeader   string
+		maxDownloads  int
+		downloadCount int
+		// incr 为 nil 表示不应计次；否则为条件更新是否命中
+		incr       *bool
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "unlimited full download counts", method: http.MethodGet,
+			incr: boolPtr(true), wantStatus: http.StatusOK, wantBody: string(content),
+		},
+		{
+			name: "unlimited resume from zero counts", method: http.MethodGet, rangeHeader: "bytes=0-4",
+			incr: boolPtr(true), wantStatus: http.StatusPartialContent, wantBody: "01234",
+		},
+		{
+			name: "unlimited later range is not counted", method: http.MethodGet, rangeHeader: "bytes=5-9",
+			wantStatus: http.StatusPartialContent, wantBody: "56789",
+		},
+		{
+			name: "limited later range is counted and served in full", method: http.MethodGet, rangeHeader: "bytes=5-9",
+			maxDownloads: 3, downloadCount: 1,
+			incr: boolPtr(true), wantStatus: http.StatusOK, wantBody: string(content),
+		},
+		{
+			name: "limited suffix range is counted", method: http.MethodGet, rangeHeader: "bytes=-5",
+			maxDownloads: 3, downloadCount: 1,
+			incr: boolPtr(true), wantStatus: http.StatusOK, wantBody: string(content),
+		},
+		{
+			name: "limit already reached", method: http.MethodGet, rangeHeader: "bytes=5-",
+			maxDownloads: 3, downloadCount: 3,
+			wantStatus: http.StatusGone,
+		},
+		{
+			name: "concurrent download took the last slot", method: http.MethodGet,
+			maxDownloads: 3, downloadCount: 2,
+			incr: boolPtr(false), wantStatus: http.StatusGone,
+		},
+		{
+			name: "HEAD is not counted", method: http.MethodHead,
+			maxDownloads: 3, downloadCount: 1,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, _ := setupTestEnv(t)
+			if _, err := store.Backend.PutObject(context.Background(), "objects/s", bytes.NewReader(content), int64(len(content)), ""); err != nil {
+				t.Fatal(err)
+			}
+
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE s.token = ? AND s.status = 0")).
+				WithArgs("tok", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows(shareColumns).AddRow(
+					1, "tok", "alice", 10, "sha1-s", "s.bin", "",
+					nil, tt.maxDownloads, tt.downloadCount, false, time.Now()))
+			if tt.incr != nil {
+				affected := int64(0)
+				if *tt.incr {
+					affected = 1
+				}
+				mock.ExpectExec(incrSQL).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, affected))
+			}
+			if tt.wantStatus != http.StatusGone {
+				expectFileMeta(mock, "sha1-s", "s.bin", int64(len(content)), "objects/s")
+			}
+
+			req := httptest.NewRequest(tt.method, "/s/tok", nil)
+			req.SetPathValue("token", "tok")
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			ShareAccessHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,141 @@
+package handler
+
+/**
+ * @Description: 登录会话与 token 签发
+ * 登录成功后签发短期 access token（JWT）和长期 refresh token（随机串，服务端保存哈希），
+ * access token 过期后客户端凭 refresh token 换取新的一对 token，refresh token 每次使用后即轮换。
+ */
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func accessTokenTTL() time.Duration {
+	return time.Duration(config.AccessTokenTTL) * time.Second
+}
+
+func refreshTokenTTL() time.Duration {
+	return time.Duration(config.RefreshTokenTTL) * time.Second
+}
+
+// randomToken 生成 256 位随机 token（base64url 编码）
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// tokenResponse 登录 / 刷新成功后返回给客户端的 token 信息
+func tokenResponse(session *redis.Session, accessToken, refreshToken string) map[string]interface{} {
+	return map[string]interface{}{
+		"username":      session.Username,
+		"token":         accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    config.AccessTokenTTL,
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
+	}
+}
+
+// requestDeviceID 客户端通过 device_id 参数或 X-Device-ID 请求头标识设备
+func requestDeviceID(r *http.Request) string {
+	if deviceID := r.FormValue("device_id"); deviceID != "" {
+		return deviceID
+	}
+	return r.Header.Get("X-Device-ID")
+}
+
+// createLoginSession 为已通过认证的用户创建登录会话并签发 token
+// 同一设备重复登录会替换旧会话，超出设备数上限时踢掉最久未活跃的设备
+func createLoginSession(r *http.Request, username, deviceID string) (map[string]interface{}, error) {
+	now := time.Now()
+	session := &redis.Session{
+		ID:        uuid.NewString(),
+		Username:  username,
+		DeviceID:  deviceID,
+		UserAgent: r.UserAgent(),
+		IP:        getClientIP(r),
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	evicted, err := redis.CreateSession(r.Context(), session, refreshTokenTTL(), config.MaxDevicesPerUser)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := redis.IssueRefreshToken(r.Context(), session, refreshTokenTTL())
+	if err != nil {
+		_ = redis.DeleteSession(r.Context(), username, session.ID)
+		return nil, err
+	}
+
+	// access token 中写入会话ID（sid），会话失效后 access token 随之失效
+	accessToken, err := auth.GenerateToken(username, session.ID, accessTokenTTL())
+	if err != nil {
+		_ = redis.DeleteSession(r.Context(), username, session.ID)
+		return nil, err
+	}
+
+	resp := tokenResponse(session, accessToken, refreshToken)
+	resp["evicted_sessions"] = len(evicted)
+	return resp, nil
+}
+
+// RefreshTokenHandler 刷新 token：POST /user/token/refresh
+// 旧 refresh token 立即作废；已作废的 refresh token 再次出现说明可能被盗用，吊销整个会话
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+		return
+	}
+
+	session, newRefreshToken, err := redis.RotateRefreshToken(r.Context(), refreshToken, refreshTokenTTL())
+	if errors.Is(err, redis.ErrRefreshTokenReused) {
+		LogOperationError(
+			r.Context(),
+			r,
+			session.Username,
+			mq.OpTokenRefresh,
+			mq.ResourceTypeSession,
+			session.ID,
+			"refresh token 重放，会话已吊销",
+		)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "refresh token reused, session revoked"})
+		return
+	}
+	if errors.Is(err, redis.ErrRefreshTokenInvalid) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to refresh token"})
+		return
+	}
+
+	accessToken, err := auth.GenerateToken(session.Username, session.ID, accessTokenTTL())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "generate token failed"})
+		return
+	}
+	_ = redis.TouchSession(r.Context(), session)
+
+	writeJSON(w, http.StatusOK, tokenResponse(session, accessToken, newRefreshToken))
+}
@@ -0,0 +1,412 @@
+package handler
+
+/**
+ * @Description: 两步验证（TOTP）
+ * 开启流程：enroll 生成密钥并返回 otpauth URI 和二维码 -> confirm 用一次口令确认后正式启用，并下发恢复码。
+ * 登录流程：密码校验通过后返回短期预认证 token，客户端再携带口令或恢复码调用 /user/signin/2fa 完成登录。
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"file-storage-linhe/config"
+	"file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"file-storage-linhe/util"
+	"net/http"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// 允许前后各一个时间步（30 秒）的时钟偏差
+	totpSkew = 1
+	// 预认证 token 有效期及最多尝试次数
+	preAuthTTL         = 5 * time.Minute
+	maxPreAuthAttempts = 5
+	// 每次下发的恢复码数量
+	recoveryCodeCount = 10
+	qrCodeSize        = 256
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes 生成恢复码，返回明文（只展示一次）和用于存储的哈希
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf)) // 8 个字符
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 忽略大小写和分隔符后计算 sha256
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyTOTPCode 校验口令，同一时间步的口令只能使用一次
+func verifyTOTPCode(ctx context.Context, username, secret, code string) (bool, error) {
+	step, ok := util.ValidateTOTP(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	// 时间步在 2*skew+1 个周期后不可能再被接受，标记保留到那时即可
+	return redis.MarkTOTPStepUsed(ctx, username, step, time.Duration(2*totpSkew+2)*30*time.Second)
+}
+
+// verifySecondFactor 校验口令或恢复码，返回使用的验证方式
+func verifySecondFactor(ctx context.Context, username, code, recoveryCode string) (string, bool, error) {
+	if recoveryCode != "" {
+		ok, err := db.UseRecoveryCode(ctx, username, hashRecoveryCode(recoveryCode))
+		return "recovery_code", ok, err
+	}
+
+	t, err := db.GetUserTOTP(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !t.Enabled) {
+		return "totp", false, nil
+	}
+	if err != nil {
+		return "totp", false, err
+	}
+	ok, err := verifyTOTPCode(ctx, username, t.Secret, code)
+	return "totp", ok, err
+}
+
+// writeTOTPUnavailable 未配置 TOTP_SECRET_KEY 时，绑定和口令校验返回 503（恢复码不受影响）
+func writeTOTPUnavailable(w http.ResponseWriter) {
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "two-factor authentication is not available"})
+}
+
+// startTOTPChallenge 密码校验通过后签发预认证 token
+func startTOTPChallenge(w http.ResponseWriter, r *http.Request, username string) {
+	token, err := randomToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if err := redis.SetPreAuth(r.Context(), token, username, preAuthTTL); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username":      username,
+		"mfa_required":  true,
+		"preauth_token": token,
+		"expires_in":    int(preAuthTTL.Seconds()),
+	})
+}
+
+// SigninTOTPHandler 两步验证登录：POST /user/signin/2fa
+func SigninTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	preAuthToken := r.FormValue("preauth_token")
+	code := r.FormValue("code")
+	recoveryCode := r.FormValue("recovery_code")
+	if preAuthToken == "" || (code == "" && recoveryCode == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "preauth_token and code or recovery_code are required"})
+		return
+	}
+
+	username, attempts, err := redis.UsePreAuth(r.Context(), preAuthToken)
+	if errors.Is(err, redis.ErrPreAuthNotFound) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired preauth token"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if attempts > maxPreAuthAttempts {
+		_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "too many attempts, sign in again"})
+		return
+	}
+
+	// 等待两步验证期间账户可能已被锁定
+	if remaining, err := redis.GetAccountLock(r.Context(), username); err == nil && remaining > 0 {
+		_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+		writeRetryAfter(w, http.StatusLocked, remaining, "account locked")
+		return
+	}
+
+	// 账户也可能已被禁用或删除
+	u, err := db.GetUserByNameWithPwd(r.Context(), username)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Status == db.UserStatusDeleted) {
+		_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired preauth token"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	lockRemaining, err := accountLockRemaining(r.Context(), u)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if lockRemaining > 0 {
+		_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+		writeRetryAfter(w, http.StatusLocked, lockRemaining, "account locked")
+		return
+	}
+	if u.Status != db.UserStatusEnabled {
+		_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "account disabled"})
+		return
+	}
+
+	method, ok, err := verifySecondFactor(r.Context(), username, code, recoveryCode)
+	if errors.Is(err, db.ErrTOTPKeyNotConfigured) {
+		writeTOTPUnavailable(w)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if !ok {
+		LogOperationError(
+			r.Context(),
+			r,
+			username,
+			mq.OpLogin,
+			mq.ResourceTypeUser,
+			username,
+			"两步验证失败",
+		)
+
+		// 口令错误同样计入登录失败次数，防止在拿到密码后暴力猜测口令
+		locked, err := recordLoginFailure(r.Context(), username, getClientIP(r), true)
+		if err == nil && locked {
+			_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+			writeRetryAfter(w, http.StatusLocked, time.Duration(config.LoginLockDuration)*time.Second, "account locked")
+			return
+		}
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid verification code"})
+		return
+	}
+
+	_ = redis.DeletePreAuth(r.Context(), preAuthToken)
+	_ = redis.ResetLoginFailure(r.Context(), redis.LoginScopeUser, username)
+
+	resp, err := createLoginSession(r, username, requestDeviceID(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create session"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpLogin,
+		mq.ResourceTypeUser,
+		username,
+		map[string]string{"mfa": method},
+	)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// TOTPEnrollHandler 生成两步验证密钥：POST /user/2fa/enroll
+func TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !db.TOTPKeyConfigured() {
+		writeTOTPUnavailable(w)
+		return
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate secret"})
+		return
+	}
+	saved, err := db.SavePendingTOTP(r.Context(), username, secret)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save secret"})
+		return
+	}
+	if !saved {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "two-factor authentication already enabled"})
+		return
+	}
+
+	uri := util.TOTPURI(config.TOTPIssuer, username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate qr code"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_png":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// TOTPConfirmHandler 输入一次口令确认并启用两步验证：POST /user/2fa/confirm
+func TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !db.TOTPKeyConfigured() {
+		writeTOTPUnavailable(w)
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "code is required"})
+		return
+	}
+
+	t, err := db.GetUserTOTP(r.Context(), username)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "two-factor authentication not enrolled"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get secret"})
+		return
+	}
+	if t.Enabled {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "two-factor authentication already enabled"})
+		return
+	}
+
+	valid, err := verifyTOTPCode(r.Context(), username, t.Secret, code)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify code"})
+		return
+	}
+	if !valid {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid verification code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate recovery codes"})
+		return
+	}
+	if err := db.EnableTOTP(r.Context(), username, hashes); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to enable two-factor authentication"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpTOTPEnable,
+		mq.ResourceTypeUser,
+		username,
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result":         "OK",
+		"recovery_codes": codes,
+	})
+}
+
+// TOTPDisableHandler 关闭两步验证（需要验证密码）：POST /user/2fa/disable
+func TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "password is required"})
+		return
+	}
+
+	u, err := db.GetUserByNameWithPwd(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get user"})
+		return
+	}
+	if !verifyPassword(u.UserPwd, password) {
+		LogOperationError(
+			r.Context(),
+			r,
+			username,
+			mq.OpTOTPDisable,
+			mq.ResourceTypeUser,
+			username,
+			"密码错误",
+		)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid password"})
+		return
+	}
+
+	if err := db.DisableTOTP(r.Context(), username); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpTOTPDisable,
+		mq.ResourceTypeUser,
+		username,
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
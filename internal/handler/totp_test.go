@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	cacheRedis "file-storage-linhe/internal/cache/redis"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTOTPUnavailableWithoutKey(t *testing.T) {
+	setupTestEnv(t)
+
+	for name, h := range map[string]http.HandlerFunc{
+		"enroll":  TOTPEnrollHandler,
+		"confirm": TOTPConfirmHandler,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/user/2fa/"+name, strings.NewReader("code=123456"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		auth.Auth(h)(rec, withLogin(t, req, "alice"))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s status = %d, want %d", name, rec.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestSigninTOTPRejectsDisabledUser(t *testing.T) {
+	mock, _ := setupTestEnv(t)
+	ctx := context.Background()
+	if err := cacheRedis.SetPreAuth(ctx, "pre-1", "alice", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_name, user_pwd, IFNULL(email, ''), IFNULL(email_validated, 0), status, role FROM tbl_user WHERE user_name = ?")).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "user_pwd", "email", "email_validated", "status", "role"}).
+			AddRow("alice", "", "", 0, db.UserStatusDisabled, db.RoleUser))
+
+	form := url.Values{"preauth_token": {"pre-1"}, "recovery_code": {"abcd-efgh"}}
+	req := httptest.NewRequest(http.MethodPost, "/user/signin/2fa", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	SigninTOTPHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, body = %s, want %d", rec.Code, rec.Body, http.StatusForbidden)
+	}
+	if _, _, err := cacheRedis.UsePreAuth(ctx, "pre-1"); !errors.Is(err, cacheRedis.ErrPreAuthNotFound) {
+		t.Errorf("preauth token not revoked: err = %v", err)
+	}
+}
@@ -2,11 +2,15 @@ package handler
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"file-storage-linhe/config"
 	"file-storage-linhe/internal/cache/redis"
 	"file-storage-linhe/internal/db"
 	"file-storage-linhe/internal/handler/auth"
 	"file-storage-linhe/internal/mq"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -64,8 +68,8 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 校验密码长度（至少6个字符）
-	if len(password) < 6 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "password must be at least 6 characters"})
+	if msg := validatePassword(password); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 		return
 	}
 
@@ -76,6 +80,26 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 邮箱可选，填写后发送验证邮件
+	var email string
+	if raw := r.FormValue("email"); raw != "" {
+		normalized, valid := normalizeEmail(raw)
+		if !valid {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
+			return
+		}
+		inUse, err := db.EmailInUse(r.Context(), normalized, username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to signup"})
+			return
+		}
+		if inUse {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "email already in use"})
+			return
+		}
+		email = normalized
+	}
+
 	// 通过 bcrypt 加密
 	hashed, err := encryptPassword(password)
 	if err != nil {
@@ -84,10 +108,15 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 写入数据库
-	if err := db.UserSingup(r.Context(), username, hashed); err != nil {
+	if err := db.UserSingup(r.Context(), username, hashed, email); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to signup"})
 		return
 	}
+	if email != "" {
+		if err := sendVerificationEmail(r.Context(), username, email); err != nil {
+			log.Printf("send verification email failed: user=%s, err=%v", username, err)
+		}
+	}
 
 	// 记录注册成功日志
 	LogOperation(
@@ -117,12 +146,46 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 退避期间直接拒绝，不再校验密码
+	ip := getClientIP(r)
+	wait, err := checkLoginThrottle(r.Context(), username, ip)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if wait > 0 {
+		writeRetryAfter(w, http.StatusTooManyRequests, wait, "too many failed attempts, retry later")
+		return
+	}
+
 	u, err := db.GetUserByNameWithPwd(r.Context(), username)
+	if err == nil && u.Status == db.UserStatusDeleted {
+		// 已删除的账户按不存在处理
+		err = sql.ErrNoRows
+	}
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_, _ = recordLoginFailure(r.Context(), username, ip, false)
+		}
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
 		return
 	}
 
+	// 账户状态校验
+	if u.Status == db.UserStatusDisabled {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "account disabled"})
+		return
+	}
+	lockRemaining, err := accountLockRemaining(r.Context(), u)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+		return
+	}
+	if lockRemaining > 0 {
+		writeRetryAfter(w, http.StatusLocked, lockRemaining, "account locked")
+		return
+	}
+
 	// 校验密码
 	if !verifyPassword(u.UserPwd, password) {
 		// 记录登录失败日志
@@ -135,21 +198,46 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 			username,
 			"密码错误",
 		)
+
+		locked, err := recordLoginFailure(r.Context(), username, ip, true)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
+			return
+		}
+		if locked {
+			LogOperation(
+				r.Context(),
+				r,
+				username,
+				mq.OpAccountLock,
+				mq.ResourceTypeUser,
+				username,
+				map[string]string{"reason": "too many failed attempts"},
+			)
+			writeRetryAfter(w, http.StatusLocked, time.Duration(config.LoginLockDuration)*time.Second, "account locked")
+			return
+		}
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
 		return
 	}
 
-	// 生成 JWT（24小时过期）
-	tokenTTL := 24 * time.Hour
-	token, err := auth.GenerateToken(username, tokenTTL)
+	// 已开启两步验证：先返回预认证 token，口令校验通过后才算登录成功
+	mfaEnabled, err := db.IsTOTPEnabled(r.Context(), username)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "generate token failed"})
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signin failed"})
 		return
 	}
+	if mfaEnabled {
+		startTOTPChallenge(w, r, username)
+		return
+	}
+
+	// 登录成功，清空该用户名的失败计数（IP 计数按窗口自然过期）
+	_ = redis.ResetLoginFailure(r.Context(), redis.LoginScopeUser, username)
 
-	// 存储到redis（实现单点登录，新token会覆盖旧token）
-	if err := redis.SetUserToken(r.Context(), username, token, tokenTTL); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set user token"})
+	resp, err := createLoginSession(r, username, requestDeviceID(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create session"})
 		return
 	}
 
@@ -164,10 +252,7 @@ func SigninHandler(w http.ResponseWriter, r *http.Request) {
 		nil,
 	)
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"username": username,
-		"token":    token,
-	})
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // 获取当前登录用户信息：GET /user/info
@@ -187,8 +272,11 @@ func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
 			return nil, err
 		}
 		return &redis.User{
-			UserName: dbUser.UserName,
-			SignupAt: dbUser.SignupAt,
+			UserName:       dbUser.UserName,
+			SignupAt:       dbUser.SignupAt,
+			Email:          dbUser.Email,
+			EmailValidated: dbUser.EmailValidated,
+			Profile:        dbUser.Profile,
 		}, nil
 	})
 	if err != nil {
@@ -197,8 +285,11 @@ func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"username":  user.UserName,
-		"signup_at": user.SignupAt,
+		"username":        user.UserName,
+		"signup_at":       user.SignupAt,
+		"email":           user.Email,
+		"email_validated": user.EmailValidated,
+		"profile":         user.Profile,
 	})
 }
 
@@ -215,7 +306,8 @@ func SignoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := redis.DeleteUserToken(r.Context(), username); err != nil {
+	sessionID, _ := auth.SessionIDFromContext(r.Context())
+	if err := redis.DeleteSession(r.Context(), username, sessionID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "signout failed"})
 		return
 	}
@@ -254,6 +346,98 @@ func OnlineDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ListSessionsHandler 列出当前用户的在线会话：GET /user/sessions
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	currentID, _ := auth.SessionIDFromContext(r.Context())
+
+	sessions, err := redis.ListSessions(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+		return
+	}
+
+	items := sessionItems(sessions, currentID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": items,
+		"count":    len(items),
+	})
+}
+
+// sessionItems 会话列表的响应格式，currentID 对应的会话标记为当前设备
+func sessionItems(sessions []*redis.Session, currentID string) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(sessions))
+	for _, s := range sessions {
+		items = append(items, map[string]interface{}{
+			"session_id": s.ID,
+			"device_id":  s.DeviceID,
+			"user_agent": s.UserAgent,
+			"ip":         s.IP,
+			"created_at": s.CreatedAt,
+			"last_seen":  s.LastSeen,
+			"current":    s.ID == currentID,
+		})
+	}
+	return items
+}
+
+// RevokeSessionHandler 远程下线指定会话：DELETE /user/sessions/{id}
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	if sessionID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "session id is required"})
+		return
+	}
+
+	// 只能下线自己的会话，他人的会话按不存在处理
+	session, err := redis.GetSession(r.Context(), sessionID)
+	if errors.Is(err, redis.ErrSessionNotFound) || (err == nil && session.Username != username) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get session"})
+		return
+	}
+
+	if err := redis.DeleteSession(r.Context(), username, sessionID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpSessionRevoke,
+		mq.ResourceTypeSession,
+		sessionID,
+		map[string]string{"device_id": session.DeviceID},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
 // UserLogsHandler 查询操作日志：GET /user/logs
 func UserLogsHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
@@ -269,6 +453,11 @@ func UserLogsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    writeUserLogs(w, r, username)
+}
+
+// writeUserLogs 返回指定用户最近的操作日志（管理员查看他人日志时复用）
+func writeUserLogs(w http.ResponseWriter, r *http.Request, username string) {
     // 获取查询参数
     limitStr := r.URL.Query().Get("limit")
     limit := 100 // 默认100条
@@ -0,0 +1,330 @@
+package handler
+
+/**
+ * @Description: 用户间共享
+ * 文件或目录可以只读（read）或读写（rw）共享给指定用户，目录共享对其下全部内容生效。
+ * 被共享者可以查看、下载共享内容；拥有 rw 权限时还可以通过 /file/upload 向共享目录上传文件（文件归共享者所有）
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage-linhe/internal/db"
+	"file-storage-linhe/internal/handler/auth"
+	"file-storage-linhe/internal/mq"
+	"net/http"
+	"strconv"
+)
+
+var errSharePermissionDenied = errors.New("permission denied")
+
+// resolveUploadFolder 解析上传目标目录
+// owner 为空或为当前用户时上传到自己的目录；否则要求当前用户对 owner 的该目录拥有 rw 共享权限
+// 返回文件最终归属的用户名
+func resolveUploadFolder(ctx context.Context, username, owner, idStr string) (string, int64, error) {
+	if owner == "" || owner == username {
+		parentID, err := resolveFolderID(ctx, username, idStr)
+		return username, parentID, err
+	}
+
+	// 根目录不能共享，只能上传到共享的目录中
+	parentID, err := resolveFolderID(ctx, owner, idStr)
+	if err != nil || parentID == db.RootFolderID {
+		return "", 0, errFolderNotFound
+	}
+	perm, err := sharedFolderPermission(ctx, username, owner, parentID)
+	if err != nil {
+		return "", 0, err
+	}
+	switch perm {
+	case db.SharePermReadWrite:
+		return owner, parentID, nil
+	case "":
+		// 没有任何共享时不暴露目录是否存在
+		return "", 0, errFolderNotFound
+	default:
+		return "", 0, errSharePermissionDenied
+	}
+}
+
+// rejectUploadOwner 只有 /file/upload 支持上传到他人共享的目录；
+// 秒传、分片上传和预签名直传的目标目录只能是自己的，带了其他用户的 owner 时返回 400，而不是悄悄上传到自己名下
+func rejectUploadOwner(w http.ResponseWriter, r *http.Request, username string) bool {
+	if owner := r.FormValue("owner"); owner != "" && owner != username {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "owner is only supported by /file/upload"})
+		return true
+	}
+	return false
+}
+
+// writeUploadFolderError 处理 resolveUploadFolder 的错误
+func writeUploadFolderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSharePermissionDenied) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "permission denied"})
+		return
+	}
+	writeFolderError(w, err)
+}
+
+// UserSharesHandler 列出 / 创建对其他用户的共享：GET、POST /share/users
+func UserSharesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listUserShares(w, r)
+	case http.MethodPost:
+		createUserShare(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listUserShares(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	shares, err := db.ListSharesByOwner(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list shares"})
+		return
+	}
+	if shares == nil {
+		shares = []*db.UserShare{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shares": shares,
+		"count":  len(shares),
+	})
+}
+
+// createUserShare 把文件或目录共享给指定用户
+// 参数：username（被共享者）、filehash 或 folder_id（二选一）、permission（read / rw，默认 read）
+func createUserShare(w http.ResponseWriter, r *http.Request) {
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+
+	s := &db.UserShare{
+		Owner:      username,
+		Grantee:    r.FormValue("username"),
+		Permission: r.FormValue("permission"),
+	}
+	switch s.Permission {
+	case "":
+		s.Permission = db.SharePermRead
+	case db.SharePermRead, db.SharePermReadWrite:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid permission"})
+		return
+	}
+
+	if s.Grantee == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username is required"})
+		return
+	}
+	if s.Grantee == username {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot share with yourself"})
+		return
+	}
+	if _, err := db.GetUserRole(ctx, s.Grantee); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		} else {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get user"})
+		}
+		return
+	}
+
+	fileHash, folderIDStr := r.FormValue("filehash"), r.FormValue("folder_id")
+	switch {
+	case fileHash != "" && folderIDStr == "":
+		// 读写权限针对目录（允许向其中上传），单个文件只能只读共享
+		if s.Permission != db.SharePermRead {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "read-write permission can only be granted on folders"})
+			return
+		}
+		uf, err := db.GetUserFileByHash(ctx, username, fileHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "file not found"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get file"})
+			return
+		}
+		s.ResourceType, s.ResourceID, s.Name = db.ShareResourceFile, uf.ID, uf.FileName
+		s.FileSha1, s.FileSize = uf.FileSha1, uf.FileSize
+	case folderIDStr != "" && fileHash == "":
+		folderID, err := resolveFolderID(ctx, username, folderIDStr)
+		if err == nil && folderID == db.RootFolderID {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot share the root folder"})
+			return
+		}
+		if err != nil {
+			writeFolderError(w, err)
+			return
+		}
+		f, err := db.GetFolder(ctx, username, folderID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get folder"})
+			return
+		}
+		s.ResourceType, s.ResourceID, s.Name = db.ShareResourceFolder, f.ID, f.Name
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "exactly one of filehash and folder_id is required"})
+		return
+	}
+
+	if err := db.UpsertUserShare(ctx, s); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create share"})
+		return
+	}
+
+	LogOperation(
+		ctx,
+		r,
+		username,
+		mq.OpUserShareCreate,
+		mq.ResourceTypeUserShare,
+		strconv.FormatInt(s.ID, 10),
+		map[string]string{
+			"grantee":       s.Grantee,
+			"resource_type": s.ResourceType,
+			"resource_id":   strconv.FormatInt(s.ResourceID, 10),
+			"permission":    s.Permission,
+		},
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"share": s})
+}
+
+// RevokeUserShareHandler 撤销共享：DELETE /share/users/{id}
+func RevokeUserShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid share id"})
+		return
+	}
+
+	revoked, err := db.RevokeUserShare(r.Context(), username, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke share"})
+		return
+	}
+	if !revoked {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "share not found"})
+		return
+	}
+
+	LogOperation(
+		r.Context(),
+		r,
+		username,
+		mq.OpUserShareRevoke,
+		mq.ResourceTypeUserShare,
+		strconv.FormatInt(id, 10),
+		nil,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": "OK"})
+}
+
+// SharedWithMeHandler 与我共享：GET /share/with-me
+func SharedWithMeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	shares, err := db.ListSharesWithUser(r.Context(), username)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list shares"})
+		return
+	}
+	if shares == nil {
+		shares = []*db.UserShare{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shares": shares,
+		"count":  len(shares),
+	})
+}
+
+// SharedFolderHandler 浏览共享给我的目录：GET /share/with-me/folder?owner=&folder_id=
+// 可以进入共享目录的任意子目录
+func SharedFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := auth.UsernameFromContext(r.Context())
+	if !ok || username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	owner := query.Get("owner")
+	folderID, err := strconv.ParseInt(query.Get("folder_id"), 10, 64)
+	if owner == "" || err != nil || folderID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "owner and folder_id are required"})
+		return
+	}
+
+	perm, err := sharedFolderPermission(ctx, username, owner, folderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check folder access"})
+		return
+	}
+	if perm == "" {
+		writeFolderError(w, errFolderNotFound)
+		return
+	}
+
+	folders, err := db.ListChildFolders(ctx, owner, folderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list folders"})
+		return
+	}
+
+	files, err := db.ListUserFilesInFolder(ctx, owner, folderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list files"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"owner":      owner,
+		"folder_id":  folderID,
+		"permission": perm,
+		"folders":    folders,
+		"files":      files,
+	})
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer 把邮件写成 .eml 文件，便于开发时直接打开查看
+func NewFileMailer(dir, from string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir, from: from}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	if err := validHeaderValue(msg.To); err != nil {
+		return err
+	}
+	safeTo := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), safeTo)
+	return os.WriteFile(filepath.Join(m.dir, name), buildRFC822(m.from, msg), 0o644)
+}
+
+type logMailer struct{}
+
+// NewLogMailer 只把邮件内容打印到日志
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("[mail] to: %s, subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,93 @@
+package mailer
+
+/**
+ * @Description: 邮件发送
+ * 通过 MAIL_BACKEND 选择实现：smtp 真实发送；file 写入本地 .eml 文件、log 打印到日志，用于开发环境
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"strings"
+	"time"
+
+	"file-storage-linhe/config"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default 全局邮件发送器，InitMailer 中初始化
+var Default Mailer
+
+// InitMailer 根据配置初始化邮件发送器
+func InitMailer() error {
+	switch config.MailBackend {
+	case "smtp":
+		Default = NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+		log.Printf("使用 SMTP 发送邮件: %s:%d", config.SMTPHost, config.SMTPPort)
+	case "file":
+		m, err := NewFileMailer(config.MailFileDir, config.MailFrom)
+		if err != nil {
+			return err
+		}
+		Default = m
+		log.Printf("邮件写入本地目录: %s", config.MailFileDir)
+	case "log":
+		Default = NewLogMailer()
+		log.Println("邮件仅打印到日志（仅用于开发/测试）")
+	default:
+		return fmt.Errorf("unknown mail backend: %s", config.MailBackend)
+	}
+	return nil
+}
+
+// Send 使用全局邮件发送器发送邮件
+func Send(ctx context.Context, msg Message) error {
+	return Default.Send(ctx, msg)
+}
+
+// SendAsync 异步发送邮件，失败只记录日志（避免接口响应时间暴露账户是否存在）
+func SendAsync(msg Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := Send(ctx, msg); err != nil {
+			log.Printf("发送邮件失败 (to: %s): %v", msg.To, err)
+		}
+	}()
+}
+
+// buildRFC822 组装邮件原文（主题按 RFC 2047 编码，正文 UTF-8 纯文本）
+func buildRFC822(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeaderValue 拒绝包含换行的地址，防止邮件头注入
+func validHeaderValue(v string) error {
+	if strings.ContainsAny(v, "\r\n") {
+		return fmt.Errorf("invalid mail header value %q", v)
+	}
+	return nil
+}
@@ -15,10 +15,11 @@ import (
 
 // FileDeleteMessage 文件删除消息
 type FileDeleteMessage struct {
-	Username  string    `json:"username"`
-	FileHash  string    `json:"file_hash"`
-	FileName  string    `json:"file_name"`
-	DeletedAt time.Time `json:"deleted_at"`
+	Username   string    `json:"username"`
+	UserFileID int64     `json:"user_file_id"` // 同一内容可能有多份副本，按记录ID删除
+	FileHash   string    `json:"file_hash"`
+	FileName   string    `json:"file_name"`
+	DeletedAt  time.Time `json:"deleted_at"`
 }
 
 // ==================== 队列配置 ====================
@@ -96,6 +97,9 @@ func InitFileDeleteQueue() error {
 
 // PublishFileDeleteMessage 发布文件删除消息到延迟队列
 func PublishFileDeleteMessage(ctx context.Context, msg *FileDeleteMessage) error {
+	if channel == nil {
+		return ErrNotConnected
+	}
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("序列化消息失败: %w", err)
@@ -118,7 +122,7 @@ func PublishFileDeleteMessage(ctx context.Context, msg *FileDeleteMessage) error
 		return fmt.Errorf("发布消息失败: %w", err)
 	}
 
-	log.Printf("发布文件删除消息: username=%s, filehash=%s", msg.Username, msg.FileHash)
+	log.Printf("发布文件删除消息: username=%s, user_file_id=%d, filehash=%s", msg.Username, msg.UserFileID, msg.FileHash)
 	return nil
 }
 
@@ -170,12 +174,13 @@ func ConsumeFileDeleteMessages(handler func(*FileDeleteMessage) error) error {
 // ==================== 辅助函数 ====================
 
 // NewFileDeleteMessage 创建文件删除消息的便捷函数
-func NewFileDeleteMessage(username, fileHash, fileName string) *FileDeleteMessage {
+func NewFileDeleteMessage(username string, userFileID int64, fileHash, fileName string) *FileDeleteMessage {
 	return &FileDeleteMessage{
-		Username:  username,
-		FileHash:  fileHash,
-		FileName:  fileName,
-		DeletedAt: time.Now(),
+		Username:   username,
+		UserFileID: userFileID,
+		FileHash:   fileHash,
+		FileName:   fileName,
+		DeletedAt:  time.Now(),
 	}
 }
 
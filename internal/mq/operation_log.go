@@ -37,12 +37,71 @@ const (
 	OpDownload = "download"
 	OpDelete   = "delete"
 	OpRestore  = "restore"
+
+	OpSessionRevoke = "session_revoke"
+	OpTokenRefresh  = "token_refresh"
+	OpAccountLock   = "account_lock"
+	OpAccountUnlock = "account_unlock"
+	OpTOTPEnable    = "totp_enable"
+	OpTOTPDisable   = "totp_disable"
+
+	OpPasswordChange       = "password_change"
+	OpPasswordResetRequest = "password_reset_request"
+	OpPasswordReset        = "password_reset"
+
+	OpEmailVerify   = "email_verify"
+	OpProfileUpdate = "profile_update"
+
+	OpAPIKeyCreate = "api_key_create"
+	OpAPIKeyRevoke = "api_key_revoke"
+
+	OpOIDCLink = "oidc_link"
+
+	OpUserDisable    = "user_disable"
+	OpUserEnable     = "user_enable"
+	OpUserDelete     = "user_delete"
+	OpUserRoleChange = "user_role_change"
+	OpForceLogout    = "force_logout"
+	OpPlanUpdate     = "plan_update"
+	OpUserPlanChange = "user_plan_change"
+
+	// 管理员查看其他用户的数据
+	OpAdminViewFiles    = "admin_view_files"
+	OpAdminViewLogs     = "admin_view_logs"
+	OpAdminViewSessions = "admin_view_sessions"
+
+	OpShareCreate = "share_create"
+	OpShareRevoke = "share_revoke"
+	OpShareAccess = "share_access"
+
+	OpUserShareCreate = "user_share_create"
+	OpUserShareRevoke = "user_share_revoke"
+
+	OpFastUpload = "fast_upload"
+
+	OpVersionRestore = "version_restore"
+	OpVersionPrune   = "version_prune"
+
+	OpFileRename = "file_rename"
+	OpFileMove   = "file_move"
+	OpFileCopy   = "file_copy"
+
+	OpFolderCreate = "folder_create"
+	OpFolderRename = "folder_rename"
+	OpFolderMove   = "folder_move"
+	OpFolderDelete = "folder_delete"
 )
 
 // 资源类型常量
 const (
-	ResourceTypeFile = "file"
-	ResourceTypeUser = "user"
+	ResourceTypeFile    = "file"
+	ResourceTypeUser    = "user"
+	ResourceTypeFolder  = "folder"
+	ResourceTypeSession = "session"
+	ResourceTypeAPIKey  = "api_key"
+	ResourceTypeShare     = "share"
+	ResourceTypeUserShare = "user_share"
+	ResourceTypePlan      = "plan"
 )
 
 // 状态常量
@@ -77,6 +136,9 @@ func InitOperationLogQueue() error {
 
 // PublishOperationLog 发布操作日志消息
 func PublishOperationLog(ctx context.Context, msg *OperationLogMessage) error {
+	if channel == nil {
+		return ErrNotConnected
+	}
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("序列化操作日志失败: %w", err)
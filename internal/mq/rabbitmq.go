@@ -1,6 +1,7 @@
 package mq
 
 import (
+	"errors"
 	"file-storage-linhe/config"
 	"fmt"
 	"log"
@@ -13,6 +14,9 @@ var (
 	channel *amqp.Channel
 )
 
+// ErrNotConnected RabbitMQ 尚未初始化
+var ErrNotConnected = errors.New("rabbitmq is not connected")
+
 // InitRabbitMQ 初始化 RabbitMQ 连接和所有队列
 func InitRabbitMQ() error {
 	var err error
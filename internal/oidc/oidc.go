@@ -0,0 +1,296 @@
+package oidc
+
+/**
+ * @Description: OpenID Connect 客户端（授权码模式 + PKCE）
+ * 首次使用时从 <issuer>/.well-known/openid-configuration 获取端点信息，
+ * ID Token 使用提供方 JWKS 中的 RSA 公钥验签
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"file-storage-linhe/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrNotConfigured 未配置 OIDC 提供方
+var ErrNotConfigured = errors.New("oidc is not configured")
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Provider 提供方端点信息
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	refreshedAt time.Time
+}
+
+// 两次拉取 JWKS 的最小间隔，避免伪造 kid 的请求反复触发拉取
+const jwksRefreshInterval = time.Minute
+
+// Claims ID Token 中用到的声明
+type Claims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+var (
+	providerMu sync.Mutex
+	provider   *Provider
+)
+
+// Enabled 是否配置了 OIDC 登录
+func Enabled() bool {
+	return config.OIDCIssuer != "" && config.OIDCClientID != ""
+}
+
+// GetProvider 获取提供方信息，首次调用时执行发现
+func GetProvider(ctx context.Context) (*Provider, error) {
+	if !Enabled() {
+		return nil, ErrNotConfigured
+	}
+
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	p := &Provider{}
+	discoveryURL := strings.TrimRight(config.OIDCIssuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, discoveryURL, p); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.Issuer != config.OIDCIssuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	provider = p
+	return p, nil
+}
+
+// NewPKCE 生成 PKCE code_verifier 和 S256 code_challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString 生成 256 位随机串（base64url），用作 state / nonce / verifier
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// AuthCodeURL 拼接跳转到提供方的授权地址
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", config.OIDCClientID)
+	q.Set("redirect_uri", config.OIDCRedirectURL)
+	q.Set("scope", config.OIDCScopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange 用授权码换取 ID Token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", config.OIDCRedirectURL)
+	form.Set("client_id", config.OIDCClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.OIDCClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.OIDCClientID), url.QueryEscape(config.OIDCClientSecret))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %d %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint: missing id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken 校验 ID Token 的签名、issuer、audience、有效期和 nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, errors.New("id token: issuer mismatch")
+	}
+	if !claims.VerifyAudience(config.OIDCClientID, true) {
+		return nil, errors.New("id token: audience mismatch")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("id token: missing exp")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token: missing sub")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// publicKey 按 kid 查找验签公钥，找不到时重新拉取一次 JWKS（提供方可能已轮换密钥）
+func (p *Provider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// 提供方只有一个密钥且未声明 kid
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("id token: unknown key %q", kid)
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	p.mu.RLock()
+	recent := time.Since(p.refreshedAt) < jwksRefreshInterval
+	p.mu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.refreshedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
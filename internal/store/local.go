@@ -0,0 +1,210 @@
+package store
+
+/**
+ * @Description: 本地磁盘存储，适合单机部署（不依赖 MinIO）
+ * 对象 key 直接映射为 root 下的相对路径，例如 files/<sha1> -> <root>/files/<sha1>
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStore struct {
+	root string
+}
+
+// NewLocalStore 创建本地磁盘存储，root 不存在时自动创建
+func NewLocalStore(root string) (ObjectStore, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, err
+	}
+	return &localStore{root: abs}, nil
+}
+
+// path 把对象 key 转成磁盘路径，拒绝跳出 root 的 key
+func (s *localStore) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if p == s.root || !strings.HasPrefix(p, s.root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid object key: %s", key)
+	}
+	return p, nil
+}
+
+// writeAtomic 先写同目录临时文件再 rename，避免读到写了一半的对象
+func (s *localStore) writeAtomic(p string, write func(f *os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	err = s.writeAtomic(p, func(f *os.File) error {
+		if size >= 0 {
+			_, err := io.CopyN(f, reader, size)
+			return err
+		}
+		_, err := io.Copy(f, reader)
+		return err
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := s.StatObject(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info.ContentType = contentType
+	return info, nil
+}
+
+func (s *localStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return f, err
+}
+
+func (s *localStore) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *localStore) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()}, nil
+}
+
+func (s *localStore) ComposeObject(ctx context.Context, dst string, srcs []string) (ObjectInfo, error) {
+	p, err := s.path(dst)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	err = s.writeAtomic(p, func(f *os.File) error {
+		for _, src := range srcs {
+			rc, err := s.GetObject(ctx, src)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return s.StatObject(ctx, dst)
+}
+
+func (s *localStore) RemoveObject(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// 从前缀所在目录开始遍历，再按完整前缀过滤
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		p, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = p
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// 跳过目录和写入中的临时文件
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
@@ -0,0 +1,136 @@
+package store
+
+/**
+ * @Description: 内存存储，用于开发调试和测试（不落盘，进程退出即丢失）
+ */
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() ObjectStore {
+	return &memoryStore{objects: make(map[string]*memoryObject)}
+}
+
+func (s *memoryStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (ObjectInfo, error) {
+	if size >= 0 {
+		reader = io.LimitReader(reader, size)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return ObjectInfo{}, io.ErrUnexpectedEOF
+	}
+
+	obj := &memoryObject{data: data, contentType: contentType, lastModified: time.Now()}
+	s.mu.Lock()
+	s.objects[key] = obj
+	s.mu.Unlock()
+	return obj.info(key), nil
+}
+
+func (s *memoryStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	// data 写入后不会被修改，可以直接共享
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (s *memoryStore) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	size := int64(len(obj.data))
+	if offset > size {
+		offset = size
+	}
+	end := offset + length
+	if end > size {
+		end = size
+	}
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), nil
+}
+
+func (s *memoryStore) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	return obj.info(key), nil
+}
+
+func (s *memoryStore) ComposeObject(ctx context.Context, dst string, srcs []string) (ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, src := range srcs {
+		obj, ok := s.objects[src]
+		if !ok {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		buf.Write(obj.data)
+	}
+	obj := &memoryObject{data: buf.Bytes(), contentType: "application/octet-stream", lastModified: time.Now()}
+	s.objects[dst] = obj
+	return obj.info(dst), nil
+}
+
+func (s *memoryStore) RemoveObject(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var objects []ObjectInfo
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (o *memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		LastModified: o.lastModified,
+	}
+}
@@ -6,7 +6,16 @@ package store
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
 
 	"file-storage-linhe/config"
 
@@ -16,6 +25,10 @@ import (
 
 var MinioClient *minio.Client
 
+// 大小未知时的分段大小。不指定时 minio-go 按 5TiB 上限推算出 528MiB，每个并发上传都会先占用这么大的缓冲区；
+// 16MiB × 10000 段，单次流式上传上限约 156GiB，更大的文件走分片上传
+const unknownSizePartSize = 16 << 20
+
 func InitMinio() error {
 	cli, err := minio.New(config.MinioEndpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(config.MinioAccessKey, config.MinioSecretKey, ""),
@@ -40,3 +53,159 @@ func InitMinio() error {
 	log.Println("MinIO连接成功！")
 	return nil
 }
+
+// minioStore 基于 MinIO 的 ObjectStore 实现
+type minioStore struct {
+	cli    *minio.Client
+	bucket string
+}
+
+// NewMinioStore 使用已有的 MinIO 客户端创建 ObjectStore
+func NewMinioStore(cli *minio.Client, bucket string) ObjectStore {
+	return &minioStore{cli: cli, bucket: bucket}
+}
+
+func (s *minioStore) PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (ObjectInfo, error) {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		opts.PartSize = unknownSizePartSize
+	}
+	info, err := s.cli.PutObject(ctx, s.bucket, key, reader, size, opts)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size, ContentType: contentType, LastModified: info.LastModified}, nil
+}
+
+func (s *minioStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	// 先 Stat 一次，把"对象不存在"统一转换成 ErrObjectNotFound
+	if _, err := s.StatObject(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.cli.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *minioStore) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if _, err := s.StatObject(ctx, key); err != nil {
+		return nil, err
+	}
+	// 长度为 0 时 MinIO 无法表达该范围，直接返回空流
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	return s.cli.GetObject(ctx, s.bucket, key, opts)
+}
+
+func (s *minioStore) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.cli.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		return ObjectInfo{}, convertMinioErr(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		Sha1:         checksumHex(info.ChecksumSHA1),
+	}, nil
+}
+
+func (s *minioStore) ComposeObject(ctx context.Context, dst string, srcs []string) (ObjectInfo, error) {
+	opts := make([]minio.CopySrcOptions, 0, len(srcs))
+	for _, src := range srcs {
+		opts = append(opts, minio.CopySrcOptions{Bucket: s.bucket, Object: src})
+	}
+	info, err := s.cli.ComposeObject(ctx, minio.CopyDestOptions{Bucket: s.bucket, Object: dst}, opts...)
+	if err != nil {
+		return ObjectInfo{}, convertMinioErr(err)
+	}
+	return ObjectInfo{Key: dst, Size: info.Size, LastModified: info.LastModified}, nil
+}
+
+func (s *minioStore) RemoveObject(ctx context.Context, key string) error {
+	return s.cli.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *minioStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range s.cli.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ContentType:  obj.ContentType,
+			LastModified: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// PresignedPostObject 用 POST 策略限定对象 key、大小和 SHA1 校验值，
+// 客户端上传的内容与校验值不一致时由存储端直接拒绝
+func (s *minioStore) PresignedPostObject(ctx context.Context, key string, expiry time.Duration, size int64, sha1Hex string) (string, map[string]string, error) {
+	sum, err := hex.DecodeString(sha1Hex)
+	if err != nil {
+		return "", nil, err
+	}
+	checksum := minio.NewChecksum(minio.ChecksumSHA1, sum)
+	if !checksum.IsSet() {
+		return "", nil, fmt.Errorf("invalid sha1: %s", sha1Hex)
+	}
+
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(s.bucket); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetKey(key); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetExpires(time.Now().Add(expiry)); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentLengthRange(size, size); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetChecksum(checksum); err != nil {
+		return "", nil, err
+	}
+	u, formData, err := s.cli.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return "", nil, err
+	}
+	return u.String(), formData, nil
+}
+
+func (s *minioStore) PresignedGetObject(ctx context.Context, key string, expiry time.Duration, fileName string) (string, error) {
+	params := url.Values{}
+	if fileName != "" {
+		params.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	u, err := s.cli.PresignedGetObject(ctx, s.bucket, key, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// checksumHex 把 MinIO 返回的 base64 校验值转换为 hex，分段上传的组合校验值等无法转换时返回空
+func checksumHex(encoded string) string {
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != sha1.Size {
+		return ""
+	}
+	return hex.EncodeToString(sum)
+}
+
+// convertMinioErr 把 MinIO 的 NoSuchKey 错误转换为 ErrObjectNotFound
+func convertMinioErr(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package store
+
+/**
+ * @Description: 基于范围读取的 io.ReadSeeker
+ * 配合 http.ServeContent 使用：Seek 只记录偏移量，真正读取时才按偏移量向存储发起范围请求
+ */
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+type objectReadSeeker struct {
+	ctx    context.Context
+	store  ObjectStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewObjectReadSeeker 创建对象的 ReadSeeker，size 为对象大小（通常来自 StatObject）
+func NewObjectReadSeeker(ctx context.Context, s ObjectStore, key string, size int64) io.ReadSeekCloser {
+	return &objectReadSeeker{ctx: ctx, store: s, key: key, size: size}
+}
+
+func (o *objectReadSeeker) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		// 从当前偏移量一直读到对象末尾，调用方读够后会 Seek 或 Close
+		body, err := o.store.GetObjectRange(o.ctx, o.key, o.offset, o.size-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	if abs != o.offset {
+		// 位置变化后丢弃当前读取流，下次 Read 重新发起范围请求
+		o.closeBody()
+		o.offset = abs
+	}
+	return abs, nil
+}
+
+func (o *objectReadSeeker) Close() error {
+	o.closeBody()
+	return nil
+}
+
+func (o *objectReadSeeker) closeBody() {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+}
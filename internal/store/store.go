@@ -0,0 +1,129 @@
+package store
+
+/**
+ * @Description: 对象存储抽象
+ * 业务层只依赖 ObjectStore 接口，具体实现（MinIO / 本地磁盘 / 内存）由配置决定
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"file-storage-linhe/config"
+)
+
+// ErrObjectNotFound 对象不存在
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo 对象基本信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// Sha1 存储端校验并记录的内容 SHA1（hex），写入时没有带校验值则为空
+	Sha1 string
+}
+
+// ObjectStore 对象存储接口
+type ObjectStore interface {
+	// PutObject 写入对象，size 为 -1 时表示大小未知，读到 EOF 为止
+	PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (ObjectInfo, error)
+	// GetObject 读取整个对象，调用方负责 Close
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetObjectRange 读取 [offset, offset+length) 范围内的数据，调用方负责 Close
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// StatObject 获取对象信息，对象不存在时返回 ErrObjectNotFound
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+	// ComposeObject 按顺序把 srcs 拼接成 dst
+	ComposeObject(ctx context.Context, dst string, srcs []string) (ObjectInfo, error)
+	// RemoveObject 删除对象，对象不存在时不报错
+	RemoveObject(ctx context.Context, key string) error
+	// ListObjects 列出指定前缀下的所有对象
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// ErrPresignNotSupported 当前存储不支持预签名 URL
+var ErrPresignNotSupported = errors.New("presigned url not supported by storage backend")
+
+// Presigner 支持预签名 URL 的存储（客户端可直接读写存储，数据不经过应用进程）
+type Presigner interface {
+	// PresignedPostObject 生成表单直传的 URL 和表单字段，
+	// 存储端拒绝大小不等于 size 或内容 SHA1 不等于 sha1（hex）的上传
+	PresignedPostObject(ctx context.Context, key string, expiry time.Duration, size int64, sha1 string) (string, map[string]string, error)
+	// PresignedGetObject fileName 非空时，下载时以该文件名保存
+	PresignedGetObject(ctx context.Context, key string, expiry time.Duration, fileName string) (string, error)
+}
+
+// Backend 当前使用的对象存储
+var Backend ObjectStore
+
+// InitStore 根据配置初始化对象存储
+func InitStore() error {
+	switch config.StorageBackend {
+	case "minio":
+		if err := InitMinio(); err != nil {
+			return err
+		}
+		Backend = NewMinioStore(MinioClient, config.MinioBucket)
+	case "local":
+		s, err := NewLocalStore(config.LocalStorageRoot)
+		if err != nil {
+			return err
+		}
+		Backend = s
+		log.Printf("使用本地磁盘存储: %s", config.LocalStorageRoot)
+	case "memory":
+		Backend = NewMemoryStore()
+		log.Println("使用内存存储（仅用于开发/测试，重启后数据丢失）")
+	default:
+		return fmt.Errorf("unknown storage backend: %s", config.StorageBackend)
+	}
+	return nil
+}
+
+// Promote 把临时对象提升为正式对象（例如 tmp/<uuid> -> files/<sha1>）
+// 使用 ComposeObject 在存储端完成拷贝，数据不经过应用进程
+func Promote(ctx context.Context, src, dst string) (ObjectInfo, error) {
+	return Backend.ComposeObject(ctx, dst, []string{src})
+}
+
+// RemovePrefix 删除指定前缀下的所有对象，返回删除的对象数和字节数
+func RemovePrefix(ctx context.Context, prefix string) (int, int64, error) {
+	objects, err := Backend.ListObjects(ctx, prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, obj := range objects {
+		if err := Backend.RemoveObject(ctx, obj.Key); err != nil {
+			return count, size, err
+		}
+		count++
+		size += obj.Size
+	}
+	return count, size, nil
+}
+
+// PresignPost 生成预签名表单直传 URL 和表单字段，当前存储不支持时返回 ErrPresignNotSupported
+func PresignPost(ctx context.Context, key string, expiry time.Duration, size int64, sha1 string) (string, map[string]string, error) {
+	p, ok := Backend.(Presigner)
+	if !ok {
+		return "", nil, ErrPresignNotSupported
+	}
+	return p.PresignedPostObject(ctx, key, expiry, size, sha1)
+}
+
+// PresignGet 生成预签名下载 URL，当前存储不支持时返回 ErrPresignNotSupported
+func PresignGet(ctx context.Context, key string, expiry time.Duration, fileName string) (string, error) {
+	p, ok := Backend.(Presigner)
+	if !ok {
+		return "", ErrPresignNotSupported
+	}
+	return p.PresignedGetObject(ctx, key, expiry, fileName)
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return []byte(strings.Repeat(string(rune(b)), 32))
+}
+
+func TestEncryptDecryptString(t *testing.T) {
+	key := testKey('k')
+	for _, plain := range []string{"", rfcSecret, "中文内容"} {
+		enc, err := EncryptString(key, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain != "" && strings.Contains(enc, plain) {
+			t.Errorf("ciphertext contains plaintext %q", plain)
+		}
+		got, err := DecryptString(key, enc)
+		if err != nil {
+			t.Fatalf("DecryptString: %v", err)
+		}
+		if got != plain {
+			t.Errorf("round trip = %q, want %q", got, plain)
+		}
+	}
+
+	// 每次加密使用新的 nonce
+	a, _ := EncryptString(key, rfcSecret)
+	b, _ := EncryptString(key, rfcSecret)
+	if a == b {
+		t.Error("two encryptions of the same plaintext are identical")
+	}
+}
+
+func TestDecryptStringRejects(t *testing.T) {
+	key := testKey('k')
+	enc, err := EncryptString(key, rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(enc)
+	raw[len(raw)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext string
+	}{
+		{"wrong key", testKey('x'), enc},
+		{"tampered", key, tampered},
+		{"not base64", key, "%%%"},
+		{"too short", key, base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"legacy plaintext", key, rfcSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptString(tt.key, tt.ciphertext); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("DecryptString() error = %v, want ErrInvalidCiphertext", err)
+			}
+		})
+	}
+}
+
+func TestParseAESKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{"32 bytes", base64.StdEncoding.EncodeToString(testKey('k')), false},
+		{"16 bytes", base64.StdEncoding.EncodeToString(testKey('k')[:16]), true},
+		{"not base64", "not base64!", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAESKey(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAESKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试密钥 "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, TOTPCounter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := TOTPCounter(now)
+	code := func(offset int64) string {
+		c, err := TOTPCode(rfcSecret, counter+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		code        string
+		skew        int
+		wantOK      bool
+		wantCounter int64
+	}{
+		{"current step", rfcSecret, code(0), 1, true, counter},
+		{"previous step within skew", rfcSecret, code(-1), 1, true, counter - 1},
+		{"next step within skew", rfcSecret, code(1), 1, true, counter + 1},
+		{"outside skew", rfcSecret, code(2), 1, false, 0},
+		{"no skew rejects previous step", rfcSecret, code(-1), 0, false, 0},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(0), 0, true, counter},
+		{"surrounding spaces", rfcSecret, " " + code(0) + " ", 0, true, counter},
+		{"wrong code", rfcSecret, "000000", 1, false, 0},
+		{"too short", rfcSecret, code(0)[:5], 1, false, 0},
+		{"too long", rfcSecret, code(0) + "0", 1, false, 0},
+		{"invalid secret", "not-base32!", code(0), 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || got != tt.wantCounter {
+				t.Errorf("ValidateTOTP() = (%d, %v), want (%d, %v)", got, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret length = %d, want 32", len(secret))
+	}
+	code, err := TOTPCode(secret, 1)
+	if err != nil {
+		t.Fatalf("generated secret is not decodable: %v", err)
+	}
+	if _, ok := ValidateTOTP(secret, code, time.Unix(totpPeriod, 0), 0); !ok {
+		t.Error("code generated from secret does not validate")
+	}
+}
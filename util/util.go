@@ -7,6 +7,7 @@ package util
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"hash"
 	"io"
 	"os"
 )
@@ -21,3 +22,32 @@ func FileSha1(file *os.File) string {
 	io.Copy(h, file)
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// Sha1Reader 边读边计算 SHA1（基于 io.TeeReader），避免为了算哈希额外读一遍数据
+type Sha1Reader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+// NewSha1Reader 包装 r，读出的每个字节都会同时写入 SHA1
+func NewSha1Reader(r io.Reader) *Sha1Reader {
+	h := sha1.New()
+	return &Sha1Reader{reader: io.TeeReader(r, h), hash: h}
+}
+
+func (s *Sha1Reader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// Sum 返回目前为止读到数据的 SHA1（十六进制）
+func (s *Sha1Reader) Sum() string {
+	return hex.EncodeToString(s.hash.Sum(nil))
+}
+
+// Size 返回目前为止读到的字节数
+func (s *Sha1Reader) Size() int64 {
+	return s.size
+}